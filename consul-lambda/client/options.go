@@ -0,0 +1,66 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"sort"
+	"time"
+)
+
+// SetOptions holds the options for writing a value to a store.
+type SetOptions struct {
+	// Tags are applied to the value in addition to the tags configured for the client.
+	Tags map[string]string
+	// Expiration is the time at which the value is deleted by the store.
+	// It is only supported by Parameter Store.
+	Expiration time.Time
+}
+
+// SetOption configures the SetOptions for writing a value.
+type SetOption func(*SetOptions)
+
+// WithTags adds the given tags to the value.
+func WithTags(tags map[string]string) SetOption {
+	return func(o *SetOptions) {
+		if o.Tags == nil {
+			o.Tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			o.Tags[k] = v
+		}
+	}
+}
+
+// WithExpiration sets the time at which the value expires.
+func WithExpiration(t time.Time) SetOption {
+	return func(o *SetOptions) {
+		o.Expiration = t
+	}
+}
+
+// NewSetOptions returns the SetOptions configured by the given options.
+func NewSetOptions(opts ...SetOption) SetOptions {
+	var o SetOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// mergeTags returns the union of the given tags sorted by key.
+// Tags in later maps take precedence.
+func mergeTags(tags ...map[string]string) ([]string, map[string]string) {
+	merged := make(map[string]string)
+	for _, t := range tags {
+		for k, v := range t {
+			merged[k] = v
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, merged
+}
@@ -0,0 +1,151 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// secretsManagerAPI is the subset of the Secrets Manager API used by the SecretsManagerClient.
+type secretsManagerAPI interface {
+	CreateSecret(context.Context, *secretsmanager.CreateSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
+	DeleteSecret(context.Context, *secretsmanager.DeleteSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error)
+	DescribeSecret(context.Context, *secretsmanager.DescribeSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+	GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	PutSecretValue(context.Context, *secretsmanager.PutSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+	RestoreSecret(context.Context, *secretsmanager.RestoreSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.RestoreSecretOutput, error)
+	TagResource(context.Context, *secretsmanager.TagResourceInput, ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error)
+}
+
+// SecretsManagerOptions holds the configuration for writing and deleting secrets with the SecretsManagerClient.
+type SecretsManagerOptions struct {
+	// RecoveryWindow is the number of days that a deleted secret can be restored.
+	// If it is zero secrets are deleted immediately, which matches the behavior of Parameter Store.
+	RecoveryWindow int
+	// KMSKeyID is the ID, ARN or alias of the KMS key used to encrypt secrets when they are created.
+	// If it is not set the account's default key for Secrets Manager is used.
+	KMSKeyID string
+	// Tags are applied to every secret that is written.
+	Tags map[string]string
+}
+
+// SecretsManagerClient provides an API client for interacting with AWS Secrets Manager.
+type SecretsManagerClient struct {
+	client secretsManagerAPI
+	opts   SecretsManagerOptions
+}
+
+// NewSecretsManager creates an instance of the SecretsManagerClient from the given AWS SDK config.
+func NewSecretsManager(cfg *aws.Config, opts SecretsManagerOptions) (*SecretsManagerClient, error) {
+	// Secrets Manager only accepts recovery windows of 7 to 30 days.
+	if opts.RecoveryWindow != 0 && (opts.RecoveryWindow < 7 || opts.RecoveryWindow > 30) {
+		return nil, fmt.Errorf("invalid Secrets Manager recovery window %d: must be 0 or between 7 and 30 days", opts.RecoveryWindow)
+	}
+	return &SecretsManagerClient{client: secretsmanager.NewFromConfig(*cfg), opts: opts}, nil
+}
+
+// Delete removes the secret for the given key from Secrets Manager.
+// The secret is scheduled for deletion after the recovery window or, if there is no recovery window,
+// it is deleted immediately.
+func (c *SecretsManagerClient) Delete(ctx context.Context, key string) error {
+	input := &secretsmanager.DeleteSecretInput{SecretId: &key}
+	if c.opts.RecoveryWindow > 0 {
+		input.RecoveryWindowInDays = aws.Int64(int64(c.opts.RecoveryWindow))
+	} else {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	}
+
+	_, err := c.client.DeleteSecret(ctx, input)
+	return err
+}
+
+// Get retrieves the value of the secret for the given key from Secrets Manager.
+// It returns an error wrapping ErrNotFound if the secret does not exist or is scheduled for deletion.
+// Other errors, including other invalid request errors, are returned as they are so that they can be retried.
+func (c *SecretsManagerClient) Get(ctx context.Context, key string) (string, error) {
+	out, err := c.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &key})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) || c.scheduledForDeletion(ctx, key, err) {
+			return "", fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return "", err
+	}
+
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secrets manager value does not exist for %s", key)
+	}
+	return *out.SecretString, nil
+}
+
+// Set writes the value of the secret for the given key to Secrets Manager.
+// The secret is created if it does not exist and restored if it is scheduled for deletion.
+// Any existing value for the given key is overwritten.
+// Secrets Manager does not support expiration so the expiration option is ignored.
+func (c *SecretsManagerClient) Set(ctx context.Context, key, val string, opts ...SetOption) error {
+	o := NewSetOptions(opts...)
+	keys, tags := mergeTags(c.opts.Tags, o.Tags)
+	secretTags := make([]types.Tag, 0, len(keys))
+	for _, k := range keys {
+		secretTags = append(secretTags, types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+
+	_, err := c.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     &key,
+		SecretString: &val,
+	})
+
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		input := &secretsmanager.CreateSecretInput{
+			Name:         &key,
+			SecretString: &val,
+		}
+		if c.opts.KMSKeyID != "" {
+			input.KmsKeyId = aws.String(c.opts.KMSKeyID)
+		}
+		if len(secretTags) > 0 {
+			input.Tags = secretTags
+		}
+		_, err = c.client.CreateSecret(ctx, input)
+		return err
+	case c.scheduledForDeletion(ctx, key, err):
+		// Secrets that are scheduled for deletion cannot be updated until they are restored.
+		if _, rerr := c.client.RestoreSecret(ctx, &secretsmanager.RestoreSecretInput{SecretId: &key}); rerr != nil {
+			return err
+		}
+		_, err = c.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     &key,
+			SecretString: &val,
+		})
+	}
+	if err != nil || len(secretTags) == 0 {
+		return err
+	}
+
+	_, err = c.client.TagResource(ctx, &secretsmanager.TagResourceInput{
+		SecretId: &key,
+		Tags:     secretTags,
+	})
+	return err
+}
+
+// scheduledForDeletion returns true if err is the invalid request error that is returned for a secret
+// that is scheduled for deletion. Secrets Manager returns the same error type for other invalid requests
+// so the secret is described to confirm that it has a deletion date.
+func (c *SecretsManagerClient) scheduledForDeletion(ctx context.Context, key string, err error) bool {
+	var invalidRequest *types.InvalidRequestException
+	if !errors.As(err, &invalidRequest) {
+		return false
+	}
+	out, err := c.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: &key})
+	return err == nil && out.DeletedDate != nil
+}
@@ -0,0 +1,179 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretsManagerClient(t *testing.T) {
+	ctx := context.Background()
+	api := &mockSecretsManager{secrets: make(map[string]*mockSecret)}
+	c := &SecretsManagerClient{client: api, opts: SecretsManagerOptions{
+		RecoveryWindow: 7,
+		KMSKeyID:       "alias/consul",
+		Tags:           map[string]string{"team": "mesh"},
+	}}
+
+	// Get fails for a secret that does not exist.
+	_, err := c.Get(ctx, "/prefix/key")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Set creates the secret and then updates it.
+	require.NoError(t, c.Set(ctx, "/prefix/key", "v1", WithTags(map[string]string{"function": "arn1"})))
+	require.Equal(t, "alias/consul", api.secrets["/prefix/key"].kmsKeyID)
+	require.Equal(t, map[string]string{"team": "mesh", "function": "arn1"}, api.secrets["/prefix/key"].tags)
+	require.NoError(t, c.Set(ctx, "/prefix/key", "v2", WithTags(map[string]string{"function": "arn2"}), WithExpiration(time.Now())))
+	require.Equal(t, map[string]string{"team": "mesh", "function": "arn2"}, api.secrets["/prefix/key"].tags)
+	v, err := c.Get(ctx, "/prefix/key")
+	require.NoError(t, err)
+	require.Equal(t, "v2", v)
+
+	// Delete schedules the deletion of the secret within the recovery window.
+	require.NoError(t, c.Delete(ctx, "/prefix/key"))
+	require.Equal(t, int64(7), api.secrets["/prefix/key"].recoveryWindow)
+	_, err = c.Get(ctx, "/prefix/key")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Set restores a secret that is scheduled for deletion.
+	require.NoError(t, c.Set(ctx, "/prefix/key", "v3"))
+	v, err = c.Get(ctx, "/prefix/key")
+	require.NoError(t, err)
+	require.Equal(t, "v3", v)
+
+	// Other invalid request errors are not treated as a missing secret.
+	api.getErr = &types.InvalidRequestException{}
+	_, err = c.Get(ctx, "/prefix/key")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+	api.getErr = nil
+
+	// Without a recovery window the secret is deleted immediately.
+	c.opts.RecoveryWindow = 0
+	require.NoError(t, c.Delete(ctx, "/prefix/key"))
+	require.NotContains(t, api.secrets, "/prefix/key")
+}
+
+func TestNewSecretsManager(t *testing.T) {
+	for _, w := range []int{0, 7, 30} {
+		_, err := NewSecretsManager(&aws.Config{}, SecretsManagerOptions{RecoveryWindow: w})
+		require.NoError(t, err)
+	}
+	for _, w := range []int{-1, 6, 31} {
+		_, err := NewSecretsManager(&aws.Config{}, SecretsManagerOptions{RecoveryWindow: w})
+		require.Error(t, err)
+	}
+}
+
+type mockSecret struct {
+	value          string
+	deleted        bool
+	recoveryWindow int64
+	kmsKeyID       string
+	tags           map[string]string
+}
+
+type mockSecretsManager struct {
+	secrets map[string]*mockSecret
+	// getErr is returned by GetSecretValue if it is not nil.
+	getErr error
+}
+
+var _ secretsManagerAPI = (*mockSecretsManager)(nil)
+
+func (m *mockSecretsManager) CreateSecret(_ context.Context, in *secretsmanager.CreateSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error) {
+	if _, ok := m.secrets[*in.Name]; ok {
+		return nil, &types.ResourceExistsException{}
+	}
+	m.secrets[*in.Name] = &mockSecret{value: *in.SecretString, kmsKeyID: aws.ToString(in.KmsKeyId), tags: toTagMap(in.Tags)}
+	return &secretsmanager.CreateSecretOutput{}, nil
+}
+
+func (m *mockSecretsManager) DeleteSecret(_ context.Context, in *secretsmanager.DeleteSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error) {
+	s, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	if aws.ToBool(in.ForceDeleteWithoutRecovery) {
+		delete(m.secrets, *in.SecretId)
+		return &secretsmanager.DeleteSecretOutput{}, nil
+	}
+	s.deleted = true
+	s.recoveryWindow = aws.ToInt64(in.RecoveryWindowInDays)
+	return &secretsmanager.DeleteSecretOutput{}, nil
+}
+
+func (m *mockSecretsManager) DescribeSecret(_ context.Context, in *secretsmanager.DescribeSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	s, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	out := &secretsmanager.DescribeSecretOutput{Name: in.SecretId}
+	if s.deleted {
+		out.DeletedDate = aws.Time(time.Now())
+	}
+	return out, nil
+}
+
+func (m *mockSecretsManager) GetSecretValue(_ context.Context, in *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	s, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	if s.deleted {
+		return nil, &types.InvalidRequestException{}
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(s.value)}, nil
+}
+
+func (m *mockSecretsManager) PutSecretValue(_ context.Context, in *secretsmanager.PutSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+	s, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	if s.deleted {
+		return nil, &types.InvalidRequestException{}
+	}
+	s.value = *in.SecretString
+	return &secretsmanager.PutSecretValueOutput{}, nil
+}
+
+func (m *mockSecretsManager) RestoreSecret(_ context.Context, in *secretsmanager.RestoreSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.RestoreSecretOutput, error) {
+	s, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	s.deleted = false
+	s.recoveryWindow = 0
+	return &secretsmanager.RestoreSecretOutput{}, nil
+}
+
+func (m *mockSecretsManager) TagResource(_ context.Context, in *secretsmanager.TagResourceInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	s, ok := m.secrets[*in.SecretId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	for k, v := range toTagMap(in.Tags) {
+		s.tags[k] = v
+	}
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
+func toTagMap(tags []types.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
@@ -5,23 +5,46 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
 )
 
+// SSMOptions holds the configuration for writing parameters with the SSMClient.
+type SSMOptions struct {
+	// Tier is the tier to use for parameters that are too large for the standard tier.
+	// If it is not set the default tier from the AWS SDK is used.
+	Tier string
+	// KMSKeyID is the ID, ARN or alias of the KMS key used to encrypt parameters.
+	// If it is not set the account's default key for Parameter Store is used.
+	KMSKeyID string
+	// Tags are applied to every parameter that is written.
+	Tags map[string]string
+}
+
+// ssmAPI is the subset of the Systems Manager API used by the SSMClient.
+type ssmAPI interface {
+	AddTagsToResource(context.Context, *ssm.AddTagsToResourceInput, ...func(*ssm.Options)) (*ssm.AddTagsToResourceOutput, error)
+	DeleteParameter(context.Context, *ssm.DeleteParameterInput, ...func(*ssm.Options)) (*ssm.DeleteParameterOutput, error)
+	GetParameter(context.Context, *ssm.GetParameterInput, ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	PutParameter(context.Context, *ssm.PutParameterInput, ...func(*ssm.Options)) (*ssm.PutParameterOutput, error)
+}
+
 // SSMClient provides an API client for interacting with AWS Systems Manager Parameter Store.
 type SSMClient struct {
-	client *ssm.Client
-	tier   string
+	client ssmAPI
+	opts   SSMOptions
 }
 
 // NewSSM creates an instance of the SSMClient from the given AWS SDK config.
-func NewSSM(cfg *aws.Config, tier string) *SSMClient {
-	return &SSMClient{client: ssm.NewFromConfig(*cfg), tier: tier}
+func NewSSM(cfg *aws.Config, opts SSMOptions) *SSMClient {
+	return &SSMClient{client: ssm.NewFromConfig(*cfg), opts: opts}
 }
 
 // Delete removes the value for the given key from Parameter Store.
@@ -32,6 +55,7 @@ func (c *SSMClient) Delete(ctx context.Context, key string) error {
 
 // Get retrieves the value for the given key from Parameter Store.
 // Get assumes that the value is encrypted as a SecureString and returns the decrypted value.
+// It returns an error wrapping ErrNotFound if the parameter does not exist.
 func (c *SSMClient) Get(ctx context.Context, key string) (string, error) {
 	paramValue, err := c.client.GetParameter(
 		ctx,
@@ -41,6 +65,10 @@ func (c *SSMClient) Get(ctx context.Context, key string) (string, error) {
 		})
 
 	if err != nil {
+		var notFound *types.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return "", fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
 		return "", err
 	}
 
@@ -54,7 +82,11 @@ func (c *SSMClient) Get(ctx context.Context, key string) (string, error) {
 // Set writes the value for the given key to Parameter Store.
 // It writes the value as an encrypted SecureString.
 // Any existing data for the given key is overwritten.
-func (c *SSMClient) Set(ctx context.Context, key, val string) error {
+//
+// If an expiration is set, an expiration policy is attached to the parameter. Parameter policies
+// are only supported by the advanced tier so the parameter is written to the advanced tier.
+func (c *SSMClient) Set(ctx context.Context, key, val string, opts ...SetOption) error {
+	o := NewSetOptions(opts...)
 	input := &ssm.PutParameterInput{
 		Name:      &key,
 		Value:     &val,
@@ -62,15 +94,66 @@ func (c *SSMClient) Set(ctx context.Context, key, val string) error {
 		Type:      types.ParameterTypeSecureString,
 	}
 
+	if c.opts.KMSKeyID != "" {
+		input.KeyId = aws.String(c.opts.KMSKeyID)
+	}
+
 	// Set the tier if one is provided; otherwise, use the default from the SSM client.
-	if c.tier != "" && len(val) > 4096 {
+	if c.opts.Tier != "" && len(val) > 4096 {
 		for _, val := range types.ParameterTierStandard.Values() {
-			if strings.EqualFold(c.tier, string(val)) {
+			if strings.EqualFold(c.opts.Tier, string(val)) {
 				input.Tier = val
 			}
 		}
 	}
 
+	if !o.Expiration.IsZero() {
+		policies, err := expirationPolicy(o.Expiration)
+		if err != nil {
+			return err
+		}
+		input.Policies = aws.String(policies)
+		input.Tier = types.ParameterTierAdvanced
+	}
+
 	_, err := c.client.PutParameter(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	// Tags cannot be passed to PutParameter when an existing parameter is overwritten
+	// so they are added to the parameter separately.
+	keys, tags := mergeTags(c.opts.Tags, o.Tags)
+	if len(keys) == 0 {
+		return nil
+	}
+	tagsInput := &ssm.AddTagsToResourceInput{
+		ResourceId:   &key,
+		ResourceType: types.ResourceTypeForTaggingParameter,
+	}
+	for _, k := range keys {
+		tagsInput.Tags = append(tagsInput.Tags, types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	_, err = c.client.AddTagsToResource(ctx, tagsInput)
 	return err
 }
+
+// parameterPolicy is a Parameter Store parameter policy.
+type parameterPolicy struct {
+	Type       string            `json:"Type"`
+	Version    string            `json:"Version"`
+	Attributes map[string]string `json:"Attributes"`
+}
+
+// expirationPolicy returns the JSON encoded parameter policies that delete a parameter at the given time.
+func expirationPolicy(t time.Time) (string, error) {
+	policies, err := json.Marshal([]parameterPolicy{{
+		Type:       "Expiration",
+		Version:    "1.0",
+		Attributes: map[string]string{"Timestamp": t.UTC().Format(time.RFC3339)},
+	}})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal parameter policy: %w", err)
+	}
+	return string(policies), nil
+}
@@ -0,0 +1,163 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSMClient_Set(t *testing.T) {
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := map[string]struct {
+		opts           SSMOptions
+		value          string
+		setOpts        []SetOption
+		expectedInput  *ssm.PutParameterInput
+		expectedTags   []types.Tag
+		expectNoTagger bool
+	}{
+		"Defaults": {
+			value: "value",
+			expectedInput: &ssm.PutParameterInput{
+				Name:      aws.String("/key"),
+				Value:     aws.String("value"),
+				Overwrite: aws.Bool(true),
+				Type:      types.ParameterTypeSecureString,
+			},
+			expectNoTagger: true,
+		},
+		"KMS key and tags": {
+			opts:    SSMOptions{KMSKeyID: "alias/consul", Tags: map[string]string{"team": "mesh", "env": "dev"}},
+			value:   "value",
+			setOpts: []SetOption{WithTags(map[string]string{"env": "prod", "function": "arn"})},
+			expectedInput: &ssm.PutParameterInput{
+				Name:      aws.String("/key"),
+				Value:     aws.String("value"),
+				Overwrite: aws.Bool(true),
+				Type:      types.ParameterTypeSecureString,
+				KeyId:     aws.String("alias/consul"),
+			},
+			expectedTags: []types.Tag{
+				{Key: aws.String("env"), Value: aws.String("prod")},
+				{Key: aws.String("function"), Value: aws.String("arn")},
+				{Key: aws.String("team"), Value: aws.String("mesh")},
+			},
+		},
+		"Large value": {
+			opts:  SSMOptions{Tier: "advanced"},
+			value: strings.Repeat("a", 5000),
+			expectedInput: &ssm.PutParameterInput{
+				Name:      aws.String("/key"),
+				Value:     aws.String(strings.Repeat("a", 5000)),
+				Overwrite: aws.Bool(true),
+				Type:      types.ParameterTypeSecureString,
+				Tier:      types.ParameterTierAdvanced,
+			},
+			expectNoTagger: true,
+		},
+		"Expiration": {
+			value:   "value",
+			setOpts: []SetOption{WithExpiration(expiration)},
+			expectedInput: &ssm.PutParameterInput{
+				Name:      aws.String("/key"),
+				Value:     aws.String("value"),
+				Overwrite: aws.Bool(true),
+				Type:      types.ParameterTypeSecureString,
+				Tier:      types.ParameterTierAdvanced,
+				Policies:  aws.String(`[{"Type":"Expiration","Version":"1.0","Attributes":{"Timestamp":"2030-01-02T03:04:05Z"}}]`),
+			},
+			expectNoTagger: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			api := &mockSSMAPI{}
+			client := &SSMClient{client: api, opts: c.opts}
+			require.NoError(t, client.Set(context.Background(), "/key", c.value, c.setOpts...))
+
+			require.Equal(t, c.expectedInput, api.put)
+			if c.expectNoTagger {
+				require.Nil(t, api.tags)
+				return
+			}
+			require.Equal(t, "/key", aws.ToString(api.tags.ResourceId))
+			require.Equal(t, types.ResourceTypeForTaggingParameter, api.tags.ResourceType)
+			require.Equal(t, c.expectedTags, api.tags.Tags)
+		})
+	}
+}
+
+func TestSSMClient_Get(t *testing.T) {
+	cases := map[string]struct {
+		err            error
+		expectedValue  string
+		expectNotFound bool
+	}{
+		"Found": {
+			expectedValue: "value",
+		},
+		"Not found": {
+			err:            &types.ParameterNotFound{},
+			expectNotFound: true,
+		},
+		"Throttled": {
+			err: &types.ThrottlingException{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client := &SSMClient{client: &mockSSMAPI{value: "value", getErr: c.err}}
+			v, err := client.Get(context.Background(), "/key")
+			if c.err == nil {
+				require.NoError(t, err)
+				require.Equal(t, c.expectedValue, v)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, c.expectNotFound, errors.Is(err, ErrNotFound))
+		})
+	}
+}
+
+type mockSSMAPI struct {
+	put    *ssm.PutParameterInput
+	tags   *ssm.AddTagsToResourceInput
+	value  string
+	getErr error
+}
+
+var _ ssmAPI = (*mockSSMAPI)(nil)
+
+func (m *mockSSMAPI) AddTagsToResource(_ context.Context, in *ssm.AddTagsToResourceInput, _ ...func(*ssm.Options)) (*ssm.AddTagsToResourceOutput, error) {
+	m.tags = in
+	return &ssm.AddTagsToResourceOutput{}, nil
+}
+
+func (m *mockSSMAPI) DeleteParameter(_ context.Context, _ *ssm.DeleteParameterInput, _ ...func(*ssm.Options)) (*ssm.DeleteParameterOutput, error) {
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+func (m *mockSSMAPI) GetParameter(_ context.Context, _ *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Value: aws.String(m.value)}}, nil
+}
+
+func (m *mockSSMAPI) PutParameter(_ context.Context, in *ssm.PutParameterInput, _ ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+	m.put = in
+	return &ssm.PutParameterOutput{}, nil
+}
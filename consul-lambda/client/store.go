@@ -0,0 +1,42 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned by the store clients when the value for a key does not exist.
+var ErrNotFound = errors.New("not found")
+
+const (
+	// StoreSSM selects AWS Systems Manager Parameter Store.
+	StoreSSM = "ssm"
+	// StoreSecretsManager selects AWS Secrets Manager.
+	StoreSecretsManager = "sm"
+
+	storeSchemeSeparator = "://"
+)
+
+// ParseStorePath splits a path of the form `[scheme://]path` into the store selected by the scheme
+// and the path within the store. Paths without a scheme are in Parameter Store.
+func ParseStorePath(s string) (string, string, error) {
+	scheme, path, ok := strings.Cut(s, storeSchemeSeparator)
+	if !ok {
+		return StoreSSM, s, nil
+	}
+
+	switch scheme {
+	case StoreSSM, StoreSecretsManager:
+		// Keep the leading slash that Parameter Store paths conventionally have.
+		if path != "" && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return scheme, path, nil
+	default:
+		return "", "", fmt.Errorf("invalid store %q in %s: must be %s or %s", scheme, s, StoreSSM, StoreSecretsManager)
+	}
+}
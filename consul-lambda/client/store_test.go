@@ -0,0 +1,40 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStorePath(t *testing.T) {
+	cases := map[string]struct {
+		path          string
+		expectedStore string
+		expectedPath  string
+		err           bool
+	}{
+		"No scheme":             {path: "/consul/data", expectedStore: StoreSSM, expectedPath: "/consul/data"},
+		"Empty":                 {path: "", expectedStore: StoreSSM, expectedPath: ""},
+		"Parameter Store":       {path: "ssm:///consul/data", expectedStore: StoreSSM, expectedPath: "/consul/data"},
+		"Secrets Manager":       {path: "sm:///consul/data", expectedStore: StoreSecretsManager, expectedPath: "/consul/data"},
+		"Without leading slash": {path: "sm://consul/data", expectedStore: StoreSecretsManager, expectedPath: "/consul/data"},
+		"Scheme without a path": {path: "sm://", expectedStore: StoreSecretsManager, expectedPath: ""},
+		"Unsupported store":     {path: "s3://bucket/data", err: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			store, path, err := ParseStorePath(c.path)
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedStore, store)
+			require.Equal(t, c.expectedPath, path)
+		})
+	}
+}
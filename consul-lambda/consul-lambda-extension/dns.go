@@ -0,0 +1,154 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/miekg/dns"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+// dnsTTL is the TTL of the DNS records for the upstreams.
+// The addresses of the upstream listeners do not change while the extension is running.
+const dnsTTL = 300
+
+// dnsServer is a DNS server that resolves the host names of the upstreams to the loopback
+// addresses that their listeners are bound to so that functions can call the upstreams by name.
+//
+// The host names of each upstream are given by structs.Service.Hostnames. Queries for any other
+// name are forwarded to the recursors or answered with NXDOMAIN if there are none.
+type dnsServer struct {
+	upstreams map[string]*structs.Service
+	recursors []string
+	logger    hclog.Logger
+
+	client *dns.Client
+	udp    *dns.Server
+	tcp    *dns.Server
+}
+
+// newDNSServer returns a DNS server for the upstreams.
+// Each recursor is the host:port address of a DNS server that other names are forwarded to.
+func newDNSServer(upstreams []*structs.Service, recursors []string, logger hclog.Logger) (*dnsServer, error) {
+	for _, r := range recursors {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			return nil, fmt.Errorf("invalid DNS recursor address %q: %w", r, err)
+		}
+	}
+	s := &dnsServer{
+		upstreams: upstreamsByHost(upstreams, logger),
+		recursors: recursors,
+		logger:    logger,
+		client:    &dns.Client{Timeout: 2 * time.Second},
+	}
+	s.udp = &dns.Server{Handler: s}
+	s.tcp = &dns.Server{Handler: s}
+	return s, nil
+}
+
+// start listens on addr for UDP and TCP queries and serves them in separate go routines that
+// report any errors to the caller via errChan.
+func (s *dnsServer) start(addr string, errChan chan error) error {
+	var err error
+	if s.udp.PacketConn, err = net.ListenPacket("udp", addr); err != nil {
+		return fmt.Errorf("failed to start DNS server: %w", err)
+	}
+	if s.tcp.Listener, err = net.Listen("tcp", addr); err != nil {
+		s.udp.PacketConn.Close()
+		return fmt.Errorf("failed to start DNS server: %w", err)
+	}
+	s.logger.Info("DNS server ready", "address", addr)
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		go func() {
+			if err := srv.ActivateAndServe(); err != nil {
+				errChan <- fmt.Errorf("failed to serve DNS: %w", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// close shuts down the DNS server.
+func (s *dnsServer) close() {
+	s.udp.Shutdown()
+	s.tcp.Shutdown()
+}
+
+// ServeDNS answers the queries for the host names of the upstreams and forwards all other queries.
+func (s *dnsServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp, err := s.resolve(w, req)
+	if err != nil {
+		s.logger.Warn("failed to resolve DNS query", "question", req.Question, "error", err)
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeServerFailure)
+	}
+	if err := w.WriteMsg(resp); err != nil {
+		s.logger.Debug("failed to write DNS response", "error", err)
+	}
+}
+
+// resolve returns the response to the query.
+func (s *dnsServer) resolve(w dns.ResponseWriter, req *dns.Msg) (*dns.Msg, error) {
+	if len(req.Question) != 1 {
+		resp := new(dns.Msg)
+		return resp.SetRcode(req, dns.RcodeFormatError), nil
+	}
+	q := req.Question[0]
+
+	upstream, ok := s.upstreams[normalizeHost(q.Name)]
+	if !ok {
+		return s.forward(w, req)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	// Upstreams that listen on a Unix domain socket do not have an address.
+	ip := net.ParseIP(upstream.BindAddress)
+	if ip == nil || q.Qclass != dns.ClassINET {
+		return resp, nil
+	}
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: dnsTTL}
+	switch ip4 := ip.To4(); {
+	case ip4 != nil && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY):
+		hdr.Rrtype = dns.TypeA
+		resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: ip4})
+	case ip4 == nil && (q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeANY):
+		hdr.Rrtype = dns.TypeAAAA
+		resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+	}
+	return resp, nil
+}
+
+// forward sends the query to each recursor in turn and returns the first response.
+func (s *dnsServer) forward(w dns.ResponseWriter, req *dns.Msg) (*dns.Msg, error) {
+	if len(s.recursors) == 0 {
+		resp := new(dns.Msg)
+		return resp.SetRcode(req, dns.RcodeNameError), nil
+	}
+
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+	client := *s.client
+	client.Net = network
+
+	var errs error
+	for _, r := range s.recursors {
+		resp, _, err := client.Exchange(req, r)
+		if err == nil {
+			return resp, nil
+		}
+		errs = errors.Join(errs, fmt.Errorf("recursor %s: %w", r, err))
+	}
+	return nil, errs
+}
@@ -8,13 +8,20 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/proxy"
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/trace"
@@ -25,10 +32,18 @@ type Config struct {
 	ServiceNamespace    string        `envconfig:"CONSUL_SERVICE_NAMESPACE"`
 	ServicePartition    string        `envconfig:"CONSUL_SERVICE_PARTITION"`
 	ServiceUpstreams    []string      `envconfig:"CONSUL_SERVICE_UPSTREAMS"`
-	MeshGatewayURI      string        `envconfig:"CONSUL_MESH_GATEWAY_URI" required:"true"`
+	MeshGatewayURIs     []string      `envconfig:"CONSUL_MESH_GATEWAY_URI"`
+	MeshGatewayTimeout  time.Duration `envconfig:"CONSUL_MESH_GATEWAY_TIMEOUT" default:"5s"`
+	MeshGatewayAddrType string        `envconfig:"CONSUL_MESH_GATEWAY_ADDRESS_TYPE" default:"wan"`
 	ExtensionDataPrefix string        `envconfig:"CONSUL_EXTENSION_DATA_PREFIX" required:"true"`
 	RefreshFrequency    time.Duration `envconfig:"CONSUL_REFRESH_FREQUENCY" default:"5m"`
 	ProxyTimeout        time.Duration `envconfig:"CONSUL_EXTENSION_PROXY_TIMEOUT" default:"3s"`
+	ConnPoolSize        int           `envconfig:"CONSUL_EXTENSION_CONN_POOL_SIZE" default:"0"`
+	ConnPoolMaxIdle     time.Duration `envconfig:"CONSUL_EXTENSION_CONN_POOL_MAX_IDLE" default:"30s"`
+	SocketDir           string        `envconfig:"CONSUL_EXTENSION_SOCKET_DIR" default:"/tmp"`
+	HTTPProxyPort       int           `envconfig:"CONSUL_EXTENSION_HTTP_PROXY_PORT" default:"0"`
+	DNSPort             int           `envconfig:"CONSUL_EXTENSION_DNS_PORT" default:"0"`
+	DNSRecursors        []string      `envconfig:"CONSUL_EXTENSION_DNS_RECURSORS"`
 
 	Store  ParamGetter
 	Events EventProcessor
@@ -37,14 +52,37 @@ type Config struct {
 
 type ParamGetter interface {
 	// Get the value for the given key.
+	// Get returns an error wrapping client.ErrNotFound if the key does not exist.
 	Get(ctx context.Context, key string) (string, error)
 }
 
+const (
+	// minRetryBackoff is the initial delay before retrying a failed extension data refresh.
+	minRetryBackoff = time.Second
+
+	// certExpiryWindow is how long before the leaf certificate expires that the extension data is refetched.
+	certExpiryWindow = time.Minute
+
+	// defaultBindAddress is the address that upstreams listen on when they do not have a bind address.
+	defaultBindAddress = "127.0.0.1"
+
+	// defaultSocketDir is the directory that Unix domain sockets are created in when SocketDir is not set.
+	defaultSocketDir = "/tmp"
+)
+
+// errExtensionDataRevoked is returned when dialing an upstream after the extension data has been deleted.
+var errExtensionDataRevoked = errors.New("extension data has been deleted because the function was removed from the service mesh")
+
+// errPeerNotFound is returned when dialing a peered upstream whose cluster peer is not in the extension data.
+var errPeerNotFound = errors.New("cluster peer not found in extension data")
+
 type EventProcessor interface {
 	// Register the event processor.
 	Register(ctx context.Context, i interface{}) error
 	// ProcessEvents handles events until the provided context is cancelled or an error occurs.
-	ProcessEvents(ctx context.Context) error
+	// onInvoke is called for each invocation of the function and must return before the next
+	// event is processed.
+	ProcessEvents(ctx context.Context, onInvoke func(context.Context)) error
 }
 
 type Extension struct {
@@ -53,8 +91,41 @@ type Extension struct {
 	proxy     *proxy.Server
 	dataMutex sync.RWMutex
 	data      structs.ExtensionData
-	dataInit  bool
+	revoked   bool
 	upstreams []*structs.Service
+
+	// nextRefresh is the time after which the extension data must be refreshed before it is used.
+	// cert is the leaf certificate parsed from the cached extension data.
+	// tlsConfigs holds the TLS configuration for dialing each upstream, built from the cached extension data.
+	// All are guarded by dataMutex.
+	nextRefresh time.Time
+	cert        *tls.Certificate
+	tlsConfigs  map[*structs.Service]*tls.Config
+
+	// gateways selects the mesh gateway for each outbound connection.
+	gateways *gateways
+
+	// httpProxy routes HTTP proxy requests to the upstreams by host name.
+	// It is nil if the HTTP proxy is disabled.
+	httpProxy *httpProxy
+
+	// httpUpstreams serves the upstreams that use the http protocol.
+	httpUpstreams []*httpUpstream
+
+	// dnsServer resolves the host names of the upstreams. It is nil if the DNS server is disabled.
+	dnsServer *dnsServer
+
+	// pools holds the pool of idle connections to the mesh gateway for each upstream.
+	// It is empty if connection pooling is disabled.
+	pools map[*structs.Service]*connPool
+
+	// conns holds the open connections to the upstreams so that they can be closed when the
+	// extension data is revoked. It is guarded by dataMutex.
+	conns map[*upstreamConn]struct{}
+
+	// refreshMutex serializes refreshes of the extension data and guards backoff.
+	refreshMutex sync.Mutex
+	backoff      time.Duration
 }
 
 // NewExtension returns an instance of the Extension from the given configuration.
@@ -71,8 +142,8 @@ func NewExtension(cfg *Config) *Extension {
 // Start executes the main processing loop for the extension.
 // It initializes and starts the proxy server and starts monitoring for incoming
 // events from the Lambda runtime.
-// It periodically retrieves the extension data from the parameter store and updates
-// the proxy configuration for the configured upstreams as necessary.
+// It retrieves the extension data from the parameter store when the extension starts and
+// refreshes it when the function is invoked, or an upstream is dialed, after it has become stale.
 func (ext *Extension) Start(ctx context.Context) error {
 	trace.Enter()
 	defer trace.Exit()
@@ -88,7 +159,22 @@ func (ext *Extension) Start(ctx context.Context) error {
 		return err
 	}
 
-	errChan := make(chan error)
+	switch ext.MeshGatewayAddrType {
+	case "", "wan", "lan":
+	default:
+		return fmt.Errorf("invalid mesh gateway address type %q: must be \"wan\" or \"lan\"", ext.MeshGatewayAddrType)
+	}
+
+	ext.gateways, err = newGateways(ext.MeshGatewayURIs, ext.Logger)
+	if err != nil {
+		return err
+	}
+
+	ext.startConnPools(ctx)
+
+	// Buffer the errors from the proxies, the HTTP upstreams, the DNS servers and the event
+	// processing loop so that none of them blocks once this func has returned.
+	errChan := make(chan error, 5+len(ext.upstreams))
 
 	// Start the proxy server and initialize all the upstream listeners so that the extension
 	// is ready to accept connections from the Lambda function as soon as it starts.
@@ -99,42 +185,180 @@ func (ext *Extension) Start(ctx context.Context) error {
 		return err
 	}
 
-	go ext.refreshExtensionData(ctx, errChan)
+	// Close the proxy on shutdown so that its listeners remove their Unix domain sockets.
+	defer ext.proxy.Close()
+	for _, u := range ext.httpUpstreams {
+		defer u.close()
+	}
+
+	// Start the HTTP proxy that routes to the upstreams by host name if it is enabled.
+	if ext.HTTPProxyPort != 0 {
+		ext.httpProxy = newHTTPProxy(ext.upstreams, ext.httpUpstreams, ext.dialUpstream, ext.Logger)
+		err = ext.httpProxy.start(net.JoinHostPort(defaultBindAddress, strconv.Itoa(ext.HTTPProxyPort)), errChan)
+		if err != nil {
+			return err
+		}
+		defer ext.httpProxy.close()
+	}
+
+	// Start the DNS server that resolves the upstreams by host name if it is enabled.
+	if ext.DNSPort != 0 {
+		ext.dnsServer, err = newDNSServer(ext.upstreams, ext.DNSRecursors, ext.Logger)
+		if err != nil {
+			return err
+		}
+		err = ext.dnsServer.start(net.JoinHostPort(defaultBindAddress, strconv.Itoa(ext.DNSPort)), errChan)
+		if err != nil {
+			return err
+		}
+		defer ext.dnsServer.close()
+	}
+
+	// Fetch the initial extension data.
+	go ext.refreshIfStale(ctx)
 	go ext.runEvents(ctx, errChan)
 
-	// Run until either the proxy returns, the event processing loop returns, or
-	// the extension data refresh loop returns.
+	// Run until either the proxy returns or the event processing loop returns.
 	return <-errChan
 }
 
-func (ext *Extension) refreshExtensionData(ctx context.Context, errChan chan error) {
+// refreshIfStale synchronously refreshes the extension data if it is stale.
+//
+// The Lambda execution environment is frozen between invocations so timers cannot be relied on to
+// refresh the extension data. Instead the extension data is refreshed on demand once it is older
+// than the refresh frequency or its leaf certificate has expired.
+func (ext *Extension) refreshIfStale(ctx context.Context) {
 	trace.Enter()
 	defer trace.Exit()
 
-	// Fetch the initial extension data.
-	err := ext.getExtensionData(ctx)
-	if err != nil {
-		errChan <- err
+	if !ext.stale() {
 		return
 	}
 
-	refresh := time.NewTicker(ext.RefreshFrequency)
-	defer refresh.Stop()
+	ext.refreshMutex.Lock()
+	defer ext.refreshMutex.Unlock()
 
-	for {
-		select {
-		case <-ctx.Done():
-			errChan <- nil
-			return
-		case <-refresh.C:
-			// The refresh interval has expired so update the extension data.
-			err := ext.getExtensionData(ctx)
-			if err != nil {
-				errChan <- err
-				return
-			}
+	// Another caller may have refreshed the data while we waited for the lock.
+	if !ext.stale() || ctx.Err() != nil {
+		return
+	}
+	ext.refreshExtensionData(ctx)
+}
+
+// stale returns true if the extension data must be refreshed before it is used.
+func (ext *Extension) stale() bool {
+	ext.dataMutex.RLock()
+	defer ext.dataMutex.RUnlock()
+	return !time.Now().Before(ext.nextRefresh)
+}
+
+// refreshExtensionData retrieves the extension data and schedules the next refresh.
+// It must be called with refreshMutex held.
+//
+// Transient errors from the store, such as throttling or network errors, do not interrupt the
+// function: the cached extension data continues to be used and the retrieval is retried with an
+// exponential backoff. If the extension data no longer exists, because the function was removed
+// from the service mesh, the cached data is discarded, all outbound connections are closed and
+// new connections are rejected until the extension data is restored.
+func (ext *Extension) refreshExtensionData(ctx context.Context) {
+	trace.Enter()
+	defer trace.Exit()
+
+	retry := false
+	err := ext.getExtensionData(ctx)
+	switch {
+	case err == nil:
+	case errors.Is(err, client.ErrNotFound):
+		ext.revokeExtensionData(err)
+	default:
+		retry = true
+		ext.Logger.Warn("failed to refresh extension data; using cached data", "error", err)
+	}
+
+	now := time.Now()
+	next := now.Add(ext.RefreshFrequency)
+
+	// Refetch the extension data shortly before the leaf certificate expires so that a rotated
+	// certificate is picked up. If the certificate is already close to expiry then the rotated
+	// certificate has not been written yet, so keep polling for it with a backoff.
+	if expiry := ext.CertExpiry(); !expiry.IsZero() {
+		refreshAt := expiry.Add(-certExpiryWindow)
+		if !refreshAt.After(now) {
+			retry = true
+			ext.Logger.Warn("leaf certificate is about to expire; waiting for a rotated certificate", "expiry", expiry)
+		} else if refreshAt.Before(next) {
+			next = refreshAt
 		}
 	}
+
+	if retry {
+		ext.backoff = nextBackoff(ext.backoff, ext.RefreshFrequency)
+		next = now.Add(ext.backoff)
+	} else {
+		ext.backoff = 0
+	}
+
+	ext.dataMutex.Lock()
+	defer ext.dataMutex.Unlock()
+	ext.nextRefresh = next
+}
+
+// CertExpiry returns the time at which the leaf certificate in the cached extension data expires.
+// It returns the zero time if there is no valid leaf certificate.
+func (ext *Extension) CertExpiry() time.Time {
+	ext.dataMutex.RLock()
+	defer ext.dataMutex.RUnlock()
+	if ext.cert == nil {
+		return time.Time{}
+	}
+	return ext.cert.Leaf.NotAfter
+}
+
+// nextBackoff returns the delay before the next retry given the previous delay.
+// The delay doubles on each retry and is capped at limit.
+func nextBackoff(prev, limit time.Duration) time.Duration {
+	next := 2 * prev
+	if prev == 0 {
+		next = minRetryBackoff
+	}
+	if next > limit {
+		next = limit
+	}
+	return next
+}
+
+// revokeExtensionData discards the cached extension data and closes all outbound connections.
+func (ext *Extension) revokeExtensionData(err error) {
+	ext.dataMutex.Lock()
+	alreadyRevoked := ext.revoked
+	ext.revoked = true
+	ext.data = structs.ExtensionData{}
+	ext.cert = nil
+	ext.tlsConfigs = nil
+	for _, pool := range ext.pools {
+		pool.close()
+	}
+	conns := ext.conns
+	ext.conns = nil
+	ext.dataMutex.Unlock()
+
+	// Close the connections that were dialed before the data was revoked, including those that the
+	// proxies are not tracking yet. They are closed without the lock because closing untracks them.
+	for conn := range conns {
+		conn.Close()
+	}
+	if alreadyRevoked {
+		return
+	}
+	ext.Logger.Error("extension data not found; the function has been removed from the service mesh. "+
+		"Closing all outbound connections and rejecting new connections", "error", err)
+	ext.proxy.CloseConns()
+	for _, u := range ext.httpUpstreams {
+		u.closeConns()
+	}
+	if ext.httpProxy != nil {
+		ext.httpProxy.closeConns()
+	}
 }
 
 func (ext *Extension) runEvents(ctx context.Context, errChan chan error) {
@@ -142,7 +366,7 @@ func (ext *Extension) runEvents(ctx context.Context, errChan chan error) {
 	defer trace.Exit()
 
 	ext.Logger.Info("processing events")
-	err := ext.Events.ProcessEvents(ctx)
+	err := ext.Events.ProcessEvents(ctx, ext.refreshIfStale)
 	if err != nil {
 		errChan <- fmt.Errorf("event processing failed with an error: %w", err)
 		return
@@ -151,35 +375,17 @@ func (ext *Extension) runEvents(ctx context.Context, errChan chan error) {
 	errChan <- nil
 }
 
-// getExtensionData asynchronously retrieves the extension data and updates the local cache.
+// getExtensionData retrieves the extension data and updates the local cache.
 func (ext *Extension) getExtensionData(ctx context.Context) error {
 	trace.Enter()
 	defer trace.Exit()
 
-	// If the extension data has not yet been initialized then we need to lock the
-	// extension data mutex so that the proxy's dial func will wait for the initial
-	// fetch to complete before attempting to dial out.
-	if !ext.dataInit {
-		ext.dataMutex.Lock()
-		defer ext.dataMutex.Unlock()
-
-		// Defer setting ext.dataInit to true until after the func exits so we don't
-		// deadlock when updating the extension data below.
-		defer func() {
-			ext.dataInit = true
-		}()
-	}
-
 	ext.Logger.Info("retrieving extension data")
 
 	// Retrieve the data.
 	key := fmt.Sprintf("%s%s", ext.ExtensionDataPrefix, ext.service.ExtensionPath())
 	d, err := ext.Store.Get(ctx, key)
 	if err != nil {
-		// TODO: Handle the distinction between transient errors from parameter store and the case where the
-		// the function's mTLS material has been deleted because the function was removed from the mesh.
-		// For transient errors we should just attempt to use the existing extension data and log any failures.
-		// For now we just err out to make sure that the function can't make any more outgoing calls.
 		return fmt.Errorf("failed to get extension data for %s: %w", key, err)
 	}
 
@@ -189,23 +395,72 @@ func (ext *Extension) getExtensionData(ctx context.Context) error {
 		return fmt.Errorf("failed to unmarshal extension data for %s: %w", key, err)
 	}
 
+	ext.dataMutex.Lock()
+	defer ext.dataMutex.Unlock()
+
+	if ext.revoked {
+		ext.Logger.Info("extension data restored; accepting new connections")
+		ext.revoked = false
+	}
+
 	// If the extension data has changed then update the cached copy.
 	if !extData.Equals(ext.data) {
-		if ext.dataInit {
-			ext.dataMutex.Lock()
-			defer ext.dataMutex.Unlock()
-		}
 		ext.data = extData
 
+		// Parse the key pair once for each update rather than for every connection.
+		ext.cert = nil
+		cert, err := tls.X509KeyPair([]byte(extData.CertPEM), []byte(extData.PrivateKeyPEM))
+		if err != nil {
+			ext.Logger.Error("failed to parse leaf certificate from extension data", "error", err)
+		} else {
+			ext.cert = &cert
+			ext.Logger.Info("updated leaf certificate", "expiry", cert.Leaf.NotAfter)
+		}
+
 		// We get the trust domain from the extension data so update the trust domain for each upstream.
-		for idx := range ext.upstreams {
-			ext.upstreams[idx].TrustDomain = ext.data.TrustDomain
+		// Peered upstreams use the trust domain of their peer.
+		for idx, upstream := range ext.upstreams {
+			if upstream.Peer == "" {
+				ext.upstreams[idx].TrustDomain = ext.data.TrustDomain
+				continue
+			}
+			peer, ok := ext.data.Peer(upstream.Peer)
+			if !ok {
+				// Connections to the upstream are rejected until the peer is in the extension data.
+				ext.Logger.Warn("cluster peer not found in extension data", "upstream", upstream.Name, "peer", upstream.Peer)
+				continue
+			}
+			ext.upstreams[idx].TrustDomain = peer.TrustDomain
 		}
+
+		// Prefer the mesh gateways discovered by the registrator over the configured addresses.
+		ext.gateways.setDiscovered(ext.meshGatewayAddrs())
+		ext.updateTLSConfigs()
 	}
 
 	return nil
 }
 
+// meshGatewayAddrs returns the addresses of the mesh gateways in the extension data.
+// It uses the configured address type and falls back to the other address when a gateway does not have one.
+// It must be called with dataMutex held.
+func (ext *Extension) meshGatewayAddrs() []string {
+	var addrs []string
+	for _, gw := range ext.data.MeshGateways {
+		addr, fallback := gw.WANAddress, gw.LANAddress
+		if ext.MeshGatewayAddrType == "lan" {
+			addr, fallback = fallback, addr
+		}
+		if addr == "" {
+			addr = fallback
+		}
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 // startProxy starts, or restarts, the extension's proxy server.
 // It retrieves the configuration for the proxy and if the configuration has changed
 // it closes the existing proxy server and reconfigures a new proxy server.
@@ -217,16 +472,30 @@ func (ext *Extension) startProxy(ctx context.Context, errChan chan error) error
 
 	ext.Logger.Info("starting proxy server")
 
-	// Create a proxy listener configuration for each upstream.
-	proxyConfigs := make([]*proxy.Config, len(ext.upstreams))
-	for i, upstream := range ext.upstreams {
-		// Create the listener config.
-		ext.Logger.Debug("configuring upstream", "name", upstream.Name, "port", upstream.Port)
-		proxyConfigs[i] = ext.proxyConfig(upstream)
+	// Create a proxy listener configuration for each TCP upstream and an HTTP server for each HTTP upstream.
+	proxyConfigs := make([]*proxy.Config, 0, len(ext.upstreams))
+	httpUpstreams := make([]*httpUpstream, 0)
+	for _, upstream := range ext.upstreams {
+		ext.Logger.Debug("configuring upstream", "upstream", upstream.String())
+		cfg := ext.proxyConfig(upstream)
+		if upstream.Protocol == structs.ProtocolHTTP {
+			httpUpstreams = append(httpUpstreams, newHTTPUpstream(upstream, cfg.ListenFunc, cfg.DialFunc, ext.Logger))
+			continue
+		}
+		proxyConfigs = append(proxyConfigs, cfg)
 	}
 
-	// Create and start the proxy server.
+	// Create and start the proxy server and the HTTP upstreams.
 	ext.proxy = proxy.New(ext.Logger, proxyConfigs...)
+	ext.httpUpstreams = httpUpstreams
+	for _, u := range ext.httpUpstreams {
+		if err := u.start(errChan); err != nil {
+			for _, u := range ext.httpUpstreams {
+				u.close()
+			}
+			return err
+		}
+	}
 	go func(errChan chan error) {
 		defer ext.proxy.Close()
 		errChan <- ext.proxy.Serve()
@@ -253,81 +522,321 @@ func (ext *Extension) proxyConfig(upstream *structs.Service) *proxy.Config {
 
 	cfg := &proxy.Config{}
 
-	// Listen on the upstream's port on all interfaces.
+	// Listen on the upstream's Unix domain socket or on its port on its loopback bind address.
 	cfg.ListenFunc = func() (net.Listener, error) {
-		return net.Listen("tcp", fmt.Sprintf(":%d", upstream.Port))
+		if upstream.SocketPath != "" {
+			return listenUnix(upstream.SocketPath)
+		}
+		return net.Listen("tcp", net.JoinHostPort(upstream.BindAddress, strconv.Itoa(upstream.Port)))
 	}
 
 	// Wrap the outgoing request in an mTLS session and dial the mesh gateway.
 	cfg.DialFunc = func() (net.Conn, error) {
-		// Get the lock for the extension data to ensure that this func picks up the
-		// latest config. This also ensures that the extension data doesn't get updated
-		// while we are dialing out.
-		ext.dataMutex.RLock()
-		defer ext.dataMutex.RUnlock()
+		return ext.dialUpstream(upstream)
+	}
+
+	return cfg
+}
+
+// dialUpstream opens an mTLS connection to the upstream through a mesh gateway.
+func (ext *Extension) dialUpstream(upstream *structs.Service) (net.Conn, error) {
+	// Make sure the extension data is fresh. This also waits for the initial fetch of the
+	// extension data, or a refresh triggered by an invocation, to complete.
+	ext.refreshIfStale(context.Background())
+
+	// Copy the configuration for the upstream out of the extension data so that the lock is not held
+	// while dialing and a slow mesh gateway does not block refreshes of the extension data.
+	ext.dataMutex.RLock()
+	tlsConfig, pool, err := ext.upstreamConfig(upstream)
+	ext.dataMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a pre-established connection if one is available.
+	if pool != nil {
+		if conn := pool.get(); conn != nil {
+			ext.Logger.Debug("using pooled connection to upstream", "sni", tlsConfig.ServerName, "port", upstream.Port)
+			return ext.trackConn(upstream, conn)
+		}
+	}
+
+	ext.Logger.Debug("dialing upstream", "sni", tlsConfig.ServerName, "port", upstream.Port)
+
+	conn, err := ext.dialGateway(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return ext.trackConn(upstream, conn)
+}
+
+// trackConn tracks the connection to the upstream until it is closed so that it is closed if the
+// extension data is revoked. The extension data may have been revoked while the connection was
+// being dialed, in which case the connection is closed and an error is returned.
+func (ext *Extension) trackConn(upstream *structs.Service, conn net.Conn) (net.Conn, error) {
+	ext.dataMutex.Lock()
+	defer ext.dataMutex.Unlock()
+	if ext.revoked {
+		conn.Close()
+		ext.Logger.Error("rejecting connection to upstream", "upstream", upstream.Name, "error", errExtensionDataRevoked)
+		return nil, errExtensionDataRevoked
+	}
+	if ext.conns == nil {
+		ext.conns = make(map[*upstreamConn]struct{})
+	}
+	c := &upstreamConn{Conn: conn, ext: ext}
+	ext.conns[c] = struct{}{}
+	return c, nil
+}
 
+// upstreamConn is a connection to an upstream that is untracked by the extension when it is closed.
+type upstreamConn struct {
+	net.Conn
+	ext *Extension
+}
+
+func (c *upstreamConn) Close() error {
+	c.ext.dataMutex.Lock()
+	delete(c.ext.conns, c)
+	c.ext.dataMutex.Unlock()
+	return c.Conn.Close()
+}
+
+// upstreamConfig returns the TLS configuration and the connection pool for dialing the upstream.
+// The pool is nil if connection pooling is disabled. It returns an error if the upstream cannot be
+// dialed with the current extension data.
+// It must be called with dataMutex held.
+func (ext *Extension) upstreamConfig(upstream *structs.Service) (*tls.Config, *connPool, error) {
+	if ext.revoked {
+		ext.Logger.Error("rejecting connection to upstream", "upstream", upstream.Name, "error", errExtensionDataRevoked)
+		return nil, nil, errExtensionDataRevoked
+	}
+	if ext.data.CertPEM == "" {
+		return nil, nil, fmt.Errorf("extension data is not available")
+	}
+	if ext.cert == nil {
+		return nil, nil, fmt.Errorf("extension data does not contain a valid leaf certificate")
+	}
+	if expiry := ext.cert.Leaf.NotAfter; !time.Now().Before(expiry) {
+		err := fmt.Errorf("certificate expired at %s and no rotated certificate is available", expiry.Format(time.RFC3339))
+		ext.Logger.Error("rejecting connection to upstream", "upstream", upstream.Name, "error", err)
+		return nil, nil, err
+	}
+
+	if upstream.Peer != "" {
+		if _, ok := ext.data.Peer(upstream.Peer); !ok {
+			err := fmt.Errorf("%w: %s", errPeerNotFound, upstream.Peer)
+			ext.Logger.Error("rejecting connection to upstream", "upstream", upstream.Name, "error", err)
+			return nil, nil, err
+		}
+	}
+	tlsConfig, ok := ext.tlsConfigs[upstream]
+	if !ok {
+		return nil, nil, fmt.Errorf("no TLS configuration for upstream %s", upstream.Name)
+	}
+	return tlsConfig, ext.pools[upstream], nil
+}
+
+// listenUnix listens on the Unix domain socket at path.
+// A socket left behind by a previous instance of the extension is removed first.
+// The socket is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("failed to listen on %s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// dialGateway opens an mTLS connection to a mesh gateway.
+// If the connection or the TLS handshake fails it fails over to the next gateway.
+func (ext *Extension) dialGateway(tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: ext.MeshGatewayTimeout}
+	return ext.gateways.dial(context.Background(), func(addr string) (net.Conn, error) {
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	})
+}
+
+// updateTLSConfigs builds the TLS configuration for dialing each upstream from the cached extension data.
+// The configurations share a new session cache so that connections made with a previous version of
+// the extension data are not resumed.
+// It must be called with dataMutex held.
+func (ext *Extension) updateTLSConfigs() {
+	ext.tlsConfigs = make(map[*structs.Service]*tls.Config, len(ext.upstreams))
+	if ext.cert == nil {
+		return
+	}
+
+	sessionCache := tls.NewLRUClientSessionCache(len(ext.upstreams))
+	for _, upstream := range ext.upstreams {
+		// Connections to peered upstreams are terminated in the peer's cluster so they are verified
+		// against the peer's CA roots.
 		roots := x509.NewCertPool()
-		roots.AppendCertsFromPEM([]byte(ext.data.RootCertPEM))
+		if upstream.Peer != "" {
+			peer, ok := ext.data.Peer(upstream.Peer)
+			if !ok {
+				continue
+			}
+			for _, rootPEM := range peer.RootCertPEMs {
+				roots.AppendCertsFromPEM([]byte(rootPEM))
+			}
+		} else {
+			roots.AppendCertsFromPEM([]byte(ext.data.RootCertPEM))
+		}
+		ext.tlsConfigs[upstream] = newTLSConfig(upstream.SNI(), roots, *ext.cert, sessionCache)
+	}
 
-		cert, err := tls.X509KeyPair([]byte(ext.data.CertPEM), []byte(ext.data.PrivateKeyPEM))
-		if err != nil {
-			return nil, err
+	// Replace the pooled connections that were established with the previous configuration.
+	for _, pool := range ext.pools {
+		pool.reset()
+	}
+}
+
+// startConnPools creates and starts a pool of idle connections to the mesh gateway for each upstream
+// if connection pooling is enabled. The pools are closed when the context is cancelled.
+func (ext *Extension) startConnPools(ctx context.Context) {
+	ext.pools = make(map[*structs.Service]*connPool)
+	if ext.ConnPoolSize <= 0 {
+		return
+	}
+
+	for _, upstream := range ext.upstreams {
+		dial := func() (net.Conn, error) {
+			ext.dataMutex.RLock()
+			tlsConfig, ok := ext.tlsConfigs[upstream]
+			ext.dataMutex.RUnlock()
+			if !ok {
+				return nil, fmt.Errorf("no TLS configuration for upstream %s", upstream.Name)
+			}
+			return ext.dialGateway(tlsConfig)
 		}
+		pool := newConnPool(dial, ext.ConnPoolSize, ext.ConnPoolMaxIdle, ext.Logger.With("upstream", upstream.Name))
+		ext.pools[upstream] = pool
+		go pool.run(ctx)
+	}
+}
 
-		ext.Logger.Debug("dialing upstream", "sni", upstream.SNI(), "port", upstream.Port)
-
-		skipTLSVerification := PRE_RELEASE == "dev"
-
-		return tls.Dial("tcp", ext.MeshGatewayURI, &tls.Config{
-			RootCAs:            roots,
-			Certificates:       []tls.Certificate{cert},
-			ServerName:         upstream.SNI(),
-			InsecureSkipVerify: skipTLSVerification,
-			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-				certs := make([]*x509.Certificate, len(rawCerts))
-				for i, asn1Data := range rawCerts {
-					cert, err := x509.ParseCertificate(asn1Data)
-					if err != nil {
-						return fmt.Errorf("failed to parse tls certificate from peer: %w", err)
-					}
-					certs[i] = cert
+// newTLSConfig returns the TLS configuration for dialing the mesh gateway with the given SNI.
+func newTLSConfig(sni string, roots *x509.CertPool, cert tls.Certificate, sessionCache tls.ClientSessionCache) *tls.Config {
+	skipTLSVerification := PRE_RELEASE == "dev"
+
+	return &tls.Config{
+		RootCAs:            roots,
+		Certificates:       []tls.Certificate{cert},
+		ServerName:         sni,
+		ClientSessionCache: sessionCache,
+		InsecureSkipVerify: skipTLSVerification,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, asn1Data := range rawCerts {
+				cert, err := x509.ParseCertificate(asn1Data)
+				if err != nil {
+					return fmt.Errorf("failed to parse tls certificate from peer: %w", err)
 				}
+				certs[i] = cert
+			}
 
-				opts := x509.VerifyOptions{
-					Roots:         roots,
-					Intermediates: x509.NewCertPool(),
-				}
+			opts := x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: x509.NewCertPool(),
+			}
 
-				// All but the first cert are intermediates.
-				for _, cert := range certs[1:] {
-					opts.Intermediates.AddCert(cert)
-				}
+			// All but the first cert are intermediates.
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
 
-				// Verify the peer cert is signed by the Consul CA.
-				// We do NOT verify the SPIFFE ID against the upstream service here because
-				// when routing through a mesh gateway the peer presents the mesh gateway's
-				// own certificate (not the upstream service's certificate). The SNI value
-				// is used purely as a routing hint to the mesh gateway.
-				_, err := certs[0].Verify(opts)
-				return err
-			},
-		})
+			// Verify the peer cert is signed by the Consul CA.
+			// We do NOT verify the SPIFFE ID against the upstream service here because
+			// when routing through a mesh gateway the peer presents the mesh gateway's
+			// own certificate (not the upstream service's certificate). The SNI value
+			// is used purely as a routing hint to the mesh gateway.
+			_, err := certs[0].Verify(opts)
+			return err
+		},
 	}
-
-	return cfg
 }
 
 func (ext *Extension) parseUpstreams() error {
 	trace.Enter()
 	defer trace.Exit()
 
-	ext.upstreams = make([]*structs.Service, 0, len(ext.ServiceUpstreams))
-	for _, s := range ext.ServiceUpstreams {
+	upstreams := splitUpstreams(ext.ServiceUpstreams)
+	ext.upstreams = make([]*structs.Service, 0, len(upstreams))
+	listeners := make(map[string]string, len(upstreams))
+	for _, s := range upstreams {
 		up, err := structs.ParseUpstream(s)
 		if err != nil {
 			return fmt.Errorf("failed to parse upstream: %w", err)
 		}
+
+		if up.SocketPath != "" {
+			path, err := ext.socketPath(up.SocketPath)
+			if err != nil {
+				return fmt.Errorf("invalid socket for upstream %s: %w", s, err)
+			}
+			up.SocketPath = path
+			if other, ok := listeners[path]; ok {
+				return fmt.Errorf("upstreams %s and %s both listen on %s", other, s, path)
+			}
+			listeners[path] = s
+			ext.upstreams = append(ext.upstreams, &up)
+			continue
+		}
+
+		// Only listen on loopback addresses so that the plaintext side of the proxy is not
+		// reachable from the network.
+		if up.BindAddress == "" {
+			up.BindAddress = defaultBindAddress
+		}
+		if ip := net.ParseIP(up.BindAddress); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("invalid bind address for upstream %s: %s is not a loopback address", s, up.BindAddress)
+		}
+		addr := net.JoinHostPort(up.BindAddress, strconv.Itoa(up.Port))
+		if other, ok := listeners[addr]; ok {
+			return fmt.Errorf("upstreams %s and %s both listen on %s", other, s, addr)
+		}
+		listeners[addr] = s
+
 		ext.upstreams = append(ext.upstreams, &up)
 	}
 	return nil
 }
+
+// socketPath returns the absolute path of an upstream's Unix domain socket.
+// Relative paths are relative to the socket directory. The socket must be within the socket directory.
+func (ext *Extension) socketPath(path string) (string, error) {
+	dir := ext.SocketDir
+	if dir == "" {
+		dir = defaultSocketDir
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not within the socket directory %s", path, dir)
+	}
+	return path, nil
+}
+
+// splitUpstreams returns the individual upstreams from the configured list.
+// The list is split on commas, which also separate the fields of the labeled upstream format,
+// so when any upstream uses the labeled format the upstreams must be separated by semicolons instead.
+func splitUpstreams(upstreams []string) []string {
+	joined := strings.Join(upstreams, ",")
+	if !strings.Contains(joined, "=") {
+		return upstreams
+	}
+	var split []string
+	for _, s := range strings.Split(joined, ";") {
+		if s = strings.TrimSpace(s); s != "" {
+			split = append(split, s)
+		}
+	}
+	return split
+}
@@ -4,41 +4,50 @@
 package main_test
 
 import (
+	"bufio"
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/consul/tlsutil"
 	"github.com/hashicorp/go-hclog"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
 	ext "github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/consul-lambda-extension"
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
 )
 
 func TestExtension(t *testing.T) {
-	const trustDomain = "1e6de438-c2bd-e632-0ce1-c0fa58607a45.consul"
 	const name = "test"
 	var wg sync.WaitGroup
-	cfg := &ext.Config{
-		MeshGatewayURI:      "mesh.gateway.consul:8443",
-		ExtensionDataPrefix: "test",
-		ServiceName:         "lambda-function",
-		ServiceUpstreams:    []string{"upstream-1:1234", "upstream-2:1235"},
-		Events:              MockEventProcessor{Wait: &wg},
-		Logger:              hclog.NewNullLogger(),
-		RefreshFrequency:    10 * time.Millisecond,
-		ProxyTimeout:        time.Millisecond,
-	}
+	cfg := testConfig(nil, "mesh.gateway.consul:8443", fmt.Sprintf("upstream-1:%d", freePort(t)), fmt.Sprintf("upstream-2:%d", freePort(t)))
+	cfg.Events = MockEventProcessor{Wait: &wg}
+	cfg.RefreshFrequency = 10 * time.Millisecond
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	extData1 := generateExtensionData(t, name, trustDomain)
-	extData2 := generateExtensionData(t, name, trustDomain)
+	extData1 := generateExtensionData(t, name, testTrustDomain)
+	extData2 := generateExtensionData(t, name, testTrustDomain)
 
 	mpg := &MockParamGetter{
 		t:             t,
@@ -50,19 +59,781 @@ func TestExtension(t *testing.T) {
 	cfg.Store = mpg
 	wg.Add(1)
 
-	e := ext.NewExtension(cfg)
+	errCh := make(chan error, 1)
+	go func() {
+		err := ext.NewExtension(cfg).Start(ctx)
+		if err != nil {
+			// If serve failed with an error, then we need to explicitly call wg.Done
+			// to end the test.
+			wg.Done()
+		}
+		errCh <- err
+	}()
+
+	wg.Wait()
+	require.NoError(t, <-errCh)
+}
+
+func TestExtension_StoreErrors(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := testConfig(nil, "mesh.gateway.consul:8443", fmt.Sprintf("upstream-1:%d", freePort(t)))
+	cfg.Events = MockEventProcessor{Wait: &wg}
+	cfg.RefreshFrequency = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
 
+	extData := generateExtensionData(t, "test", testTrustDomain)
+
+	// Neither transient errors nor the deletion of the extension data stop the extension.
+	mpg := &MockParamGetter{
+		t:             t,
+		cancel:        cancel,
+		wg:            &wg,
+		path:          "test/default/default/lambda-function",
+		extensionData: []string{extData, "", extData, "", extData},
+		errs:          []error{nil, errors.New("throttled"), nil, fmt.Errorf("%w: deleted", client.ErrNotFound), nil},
+	}
+	cfg.Store = mpg
+	wg.Add(1)
+
+	errCh := make(chan error, 1)
 	go func() {
-		err := e.Start(ctx)
+		err := ext.NewExtension(cfg).Start(ctx)
 		if err != nil {
 			// If serve failed with an error, then we need to explicitly call wg.Done
 			// to end the test.
 			wg.Done()
 		}
-		require.NoError(t, err)
+		errCh <- err
 	}()
 
 	wg.Wait()
+	require.NoError(t, <-errCh)
+}
+
+func TestExtension_Revoked(t *testing.T) {
+
+	gateway := newMockGateway(t)
+	port := freePort(t)
+
+	store := &MockStore{data: generateExtensionData(t, "test", testTrustDomain)}
+	cfg := testConfig(store, gateway.addr(), fmt.Sprintf("upstream-1:%d", port))
+	cfg.Events = MockEventProcessor{Wait: &sync.WaitGroup{}}
+	cfg.RefreshFrequency = 10 * time.Millisecond
+
+	startExtension(t, cfg)
+
+	dialGateway := func() bool { return gateway.proxied(port) }
+
+	// Connections are proxied to the mesh gateway while the extension data exists.
+	require.Eventually(t, dialGateway, 5*time.Second, 10*time.Millisecond)
+
+	// Connections are rejected once the extension data is deleted.
+	store.setNotFound(true)
+	require.Eventually(t, func() bool { return !dialGateway() }, 5*time.Second, 10*time.Millisecond)
+	require.False(t, dialGateway())
+
+	// Connections are proxied again when the extension data is restored.
+	store.setNotFound(false)
+	require.Eventually(t, dialGateway, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestExtension_RevokedWhileDialing(t *testing.T) {
+	const refreshFrequency = 50 * time.Millisecond
+
+	ca := generateTestCA(t, testTrustDomain)
+	port := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("upstream-1:%d", port))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+
+	// The gateway holds off the TLS handshake of each connection until it is released.
+	l := newGatewayListener(t, ca, false, upstream.SNI())
+	dialing, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dialing <- struct{}{}
+			go func() {
+				defer conn.Close()
+				<-release
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	store := &MockStore{data: ca.extensionData(t, "test", 1)}
+	events := &MockInvoker{invokes: make(chan chan struct{})}
+	cfg := testConfig(store, l.Addr().String(), fmt.Sprintf("upstream-1:%d", port))
+	cfg.Events = events
+	cfg.RefreshFrequency = refreshFrequency
+
+	startExtension(t, cfg)
+	require.Eventually(t, func() bool { return store.getCount() == 1 }, time.Second, time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Write([]byte("a"))
+	require.NoError(t, err)
+
+	// The extension data is revoked while the connection to the gateway is being established.
+	<-dialing
+	store.setNotFound(true)
+	time.Sleep(refreshFrequency)
+	events.invoke()
+	close(release)
+
+	// The connection is closed rather than proxied once it has been established.
+	_, err = io.ReadFull(conn, make([]byte, 1))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestExtension_RefreshOnInvoke(t *testing.T) {
+	const refreshFrequency = 100 * time.Millisecond
+
+	store := &MockStore{data: generateExtensionData(t, "test", testTrustDomain)}
+	events := &MockInvoker{invokes: make(chan chan struct{})}
+	cfg := testConfig(store, "mesh.gateway.consul:8443")
+	cfg.Events = events
+	cfg.RefreshFrequency = refreshFrequency
+
+	startExtension(t, cfg)
+
+	// The extension data is retrieved when the extension starts.
+	require.Eventually(t, func() bool { return store.getCount() == 1 }, time.Second, time.Millisecond)
+
+	// The extension data is not refreshed while it is fresh.
+	events.invoke()
+	require.Equal(t, 1, store.getCount())
+
+	// Stale extension data is refreshed before the invocation is released.
+	time.Sleep(refreshFrequency)
+	events.invoke()
+	require.Equal(t, 2, store.getCount())
+}
+
+func TestExtension_CertExpired(t *testing.T) {
+
+	gateway := newMockGateway(t)
+	port := freePort(t)
+
+	store := &MockStore{data: generateExtensionDataWithDays(t, "test", testTrustDomain, 0)}
+	cfg := testConfig(store, gateway.addr(), fmt.Sprintf("upstream-1:%d", port))
+	cfg.RefreshFrequency = 50 * time.Millisecond
+
+	e := startExtension(t, cfg)
+
+	dialGateway := func() bool { return gateway.proxied(port) }
+
+	// Connections are rejected while the leaf certificate is expired and the extension data is refetched.
+	require.Eventually(t, func() bool { return !e.CertExpiry().IsZero() }, time.Second, time.Millisecond)
+	require.False(t, e.CertExpiry().After(time.Now()))
+	require.Eventually(t, func() bool { return !dialGateway() && store.getCount() > 2 }, time.Second, 10*time.Millisecond)
+
+	// Connections are proxied once a rotated certificate is available.
+	store.setData(generateExtensionData(t, "test", testTrustDomain))
+	require.Eventually(t, dialGateway, 5*time.Second, 10*time.Millisecond)
+	require.True(t, e.CertExpiry().After(time.Now()))
+}
+
+// BenchmarkExtensionDial measures the time to open a connection to an upstream through the mesh gateway.
+// The gateway either allows TLS sessions to be resumed or forces a full handshake for every connection.
+func BenchmarkExtensionDial(b *testing.B) {
+
+	cases := map[string]struct {
+		disableSessionTickets bool
+	}{
+		"session resumption": {},
+		"full handshake":     {disableSessionTickets: true},
+	}
+
+	for name, c := range cases {
+		b.Run(name, func(b *testing.B) {
+			ca := generateTestCA(b, testTrustDomain)
+			port := freePort(b)
+
+			upstream, err := structs.ParseUpstream(fmt.Sprintf("upstream-1:%d", port))
+			require.NoError(b, err)
+			upstream.TrustDomain = testTrustDomain
+
+			gateway := newTLSGateway(b, ca, upstream.SNI(), c.disableSessionTickets)
+
+			cfg := testConfig(&MockStore{data: ca.extensionData(b, "test", 1)}, gateway.addr(), fmt.Sprintf("upstream-1:%d", port))
+
+			startExtension(b, cfg)
+
+			roundTrip := func() error { return echo(port) }
+
+			// Wait for the extension to be ready.
+			require.Eventually(b, func() bool { return roundTrip() == nil }, 5*time.Second, 10*time.Millisecond)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, roundTrip())
+			}
+		})
+	}
+}
+
+func TestExtension_ConnPool(t *testing.T) {
+	const refreshFrequency = 50 * time.Millisecond
+
+	ca := generateTestCA(t, testTrustDomain)
+	port := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("upstream-1:%d", port))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+	gateway := newTLSGateway(t, ca, upstream.SNI(), false)
+
+	store := &MockStore{data: ca.extensionData(t, "test", 1)}
+	events := &MockInvoker{invokes: make(chan chan struct{})}
+	cfg := testConfig(store, gateway.addr(), fmt.Sprintf("upstream-1:%d", port))
+	cfg.Events = events
+	cfg.RefreshFrequency = refreshFrequency
+	cfg.ConnPoolSize = 2
+	cfg.ConnPoolMaxIdle = time.Minute
+
+	startExtension(t, cfg)
+
+	// The pool is filled as soon as the extension data is available.
+	require.Eventually(t, func() bool { return gateway.connCount() == 2 }, 5*time.Second, time.Millisecond)
+
+	// A connection from the function uses a pooled connection and the pool is refilled.
+	require.NoError(t, echo(port))
+	require.Eventually(t, func() bool { return gateway.connCount() == 3 }, 5*time.Second, time.Millisecond)
+
+	// The pooled connections are replaced when the extension data changes.
+	store.setData(ca.extensionData(t, "test", 1))
+	time.Sleep(refreshFrequency)
+	events.invoke()
+	require.Eventually(t, func() bool { return gateway.connCount() == 5 }, 5*time.Second, time.Millisecond)
+	require.Never(t, func() bool { return gateway.connCount() > 5 }, 100*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestExtension_GatewayFailover(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("upstream-1:%d", port))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+
+	// The first gateway fails the TLS handshake. The last gateway is addressed by a DNS name.
+	failing := newMockGateway(t)
+	gateway1 := newTLSGateway(t, ca, upstream.SNI(), false)
+	gateway2 := newTLSGateway(t, ca, upstream.SNI(), false)
+	_, gateway2Port, err := net.SplitHostPort(gateway2.addr())
+	require.NoError(t, err)
+
+	cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, failing.addr(), fmt.Sprintf("upstream-1:%d", port))
+	cfg.MeshGatewayURIs = append(cfg.MeshGatewayURIs, gateway1.addr(), net.JoinHostPort("localhost", gateway2Port))
+	cfg.MeshGatewayTimeout = time.Second
+
+	startExtension(t, cfg)
+
+	// Connections fail over from the failing gateway and are balanced across the healthy gateways.
+	require.Eventually(t, func() bool { return echo(port) == nil }, 5*time.Second, 10*time.Millisecond)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, echo(port))
+	}
+	require.Positive(t, gateway1.connCount())
+	require.Positive(t, gateway2.connCount())
+}
+
+func TestExtension_DiscoveredGateways(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("upstream-1:%d", port))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+
+	configured := newMockGateway(t)
+	gateway1 := newTLSGateway(t, ca, upstream.SNI(), false)
+	gateway2 := newTLSGateway(t, ca, upstream.SNI(), false)
+
+	// The WAN address of the discovered gateway is used by default.
+	store := &MockStore{data: ca.extensionData(t, "test", 1, structs.MeshGateway{
+		LANAddress: configured.addr(),
+		WANAddress: gateway1.addr(),
+	})}
+	cfg := testConfig(store, configured.addr(), fmt.Sprintf("upstream-1:%d", port))
+	cfg.MeshGatewayTimeout = time.Second
+	cfg.RefreshFrequency = 50 * time.Millisecond
+
+	startExtension(t, cfg)
+
+	require.Eventually(t, func() bool { return echo(port) == nil }, 5*time.Second, 10*time.Millisecond)
+	require.Positive(t, gateway1.connCount())
+
+	// The LAN address is used when a gateway does not have a WAN address.
+	store.setData(ca.extensionData(t, "test", 1, structs.MeshGateway{LANAddress: gateway2.addr()}))
+	require.Eventually(t, func() bool { return echo(port) == nil && gateway2.connCount() > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// The configured gateway is used when no gateways are discovered.
+	store.setData(ca.extensionData(t, "test", 1))
+	require.Eventually(t, func() bool {
+		_ = echo(port)
+		return len(configured.accepted) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestExtension_LabeledUpstreams(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port1 := freePort(t)
+	port2 := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("service=upstream-1,subset=v2,port=%d,bind=127.0.0.1", port1))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+	gateway := newTLSGateway(t, ca, upstream.SNI(), false)
+
+	// Labeled upstreams are separated by semicolons. The list is split on commas when it is read from the environment.
+	upstreams := fmt.Sprintf("service=upstream-1,subset=v2,port=%d,bind=127.0.0.1; upstream-2:%d", port1, port2)
+	cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, gateway.addr(), strings.Split(upstreams, ",")...)
+
+	startExtension(t, cfg)
+
+	// Connections to the labeled upstream are routed to its subset.
+	require.Eventually(t, func() bool { return echo(port1) == nil }, 5*time.Second, 10*time.Millisecond)
+
+	// The unlabeled upstream is also listening.
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port2))
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestExtension_SubsetRouting(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port1 := freePort(t)
+	port2 := freePort(t)
+
+	// The gateway only presents a certificate for the v2 subset of the upstream.
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("service=upstream-1,subset=v2,port=%d", port2))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+	gateway := newTLSGateway(t, ca, upstream.SNI(), false)
+
+	cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, gateway.addr(), fmt.Sprintf("service=upstream-1,subset=v1,port=%d;service=upstream-1,subset=v2,port=%d", port1, port2))
+
+	startExtension(t, cfg)
+
+	// Each upstream listener routes to its own subset.
+	require.Eventually(t, func() bool { return echo(port2) == nil }, 5*time.Second, 10*time.Millisecond)
+	require.Error(t, echo(port1))
+}
+
+func TestExtension_BindAddress(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("service=upstream-1,port=%d,bind=127.0.0.2", port))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+	gateway := newTLSGateway(t, ca, upstream.SNI(), false)
+
+	newConfig := func(upstreams string) *ext.Config {
+		return testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, gateway.addr(), upstreams)
+	}
+
+	// Upstreams must bind to distinct loopback addresses.
+	err = ext.NewExtension(newConfig(fmt.Sprintf("service=upstream-1,port=%d,bind=10.0.0.1", port))).Start(context.Background())
+	require.ErrorContains(t, err, "10.0.0.1 is not a loopback address")
+	err = ext.NewExtension(newConfig(fmt.Sprintf("upstream-1:%d;service=upstream-2,port=%d,bind=127.0.0.1", port, port))).Start(context.Background())
+	require.ErrorContains(t, err, fmt.Sprintf("both listen on 127.0.0.1:%d", port))
+
+	// Upstreams share a port on different loopback addresses. Upstreams listen on 127.0.0.1 by default.
+	upstreams := fmt.Sprintf("service=upstream-1,port=%[1]d,bind=127.0.0.2;service=upstream-2,port=%[1]d,bind=127.0.0.3;upstream-3:%[1]d", port)
+	startExtension(t, newConfig(upstreams))
+
+	addr := func(ip string) string { return net.JoinHostPort(ip, strconv.Itoa(port)) }
+	require.Eventually(t, func() bool { return echoAddr(addr("127.0.0.2")) == nil }, 5*time.Second, 10*time.Millisecond)
+	for _, ip := range []string{"127.0.0.1", "127.0.0.3"} {
+		conn, err := net.Dial("tcp", addr(ip))
+		require.NoError(t, err)
+		conn.Close()
+	}
+}
+
+func TestExtension_UnixSocket(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	upstream, err := structs.ParseUpstream("service=upstream-1,socket=api.sock")
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+	gateway := newTLSGateway(t, ca, upstream.SNI(), false)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.sock")
+	newConfig := func(upstreams string) *ext.Config {
+		cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, gateway.addr(), upstreams)
+		cfg.SocketDir = dir
+		return cfg
+	}
+
+	// Sockets must be within the socket directory.
+	err = ext.NewExtension(newConfig("service=upstream-1,socket=/var/run/api.sock")).Start(context.Background())
+	require.ErrorContains(t, err, "is not within the socket directory")
+	err = ext.NewExtension(newConfig("service=upstream-1,socket=../api.sock")).Start(context.Background())
+	require.ErrorContains(t, err, "is not within the socket directory")
+
+	// Leave a stale socket behind as if the extension had been killed.
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errCh := make(chan error, 1)
+	go func() { errCh <- ext.NewExtension(newConfig("service=upstream-1,socket=api.sock")).Start(ctx) }()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte("a")); err != nil {
+			return false
+		}
+		_, err = io.ReadFull(conn, make([]byte, 1))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The socket is removed on shutdown.
+	cancel()
+	require.NoError(t, <-errCh)
+	require.NoFileExists(t, path)
+}
+
+func TestExtension_HTTPProxy(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	proxyPort := freePort(t)
+	upstreams := fmt.Sprintf("upstream-1:%d;service=upstream-2,subset=v2,port=%d", freePort(t), freePort(t))
+
+	// The gateway responds with the SNI of the upstream, the host and the path of the request.
+	var snis []string
+	for _, s := range strings.Split(upstreams, ";") {
+		upstream, err := structs.ParseUpstream(s)
+		require.NoError(t, err)
+		upstream.TrustDomain = testTrustDomain
+		snis = append(snis, upstream.SNI())
+	}
+	gateway := newHTTPGateway(t, ca, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", r.TLS.ServerName, r.Host, r.URL.Path)
+	}), snis...)
+
+	cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, gateway, upstreams)
+	cfg.HTTPProxyPort = proxyPort
+
+	startExtension(t, cfg)
+
+	proxyAddr := fmt.Sprintf("127.0.0.1:%d", proxyPort)
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	require.NoError(t, err)
+	httpClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(u string) (int, string) {
+		resp, err := httpClient.Get(u)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	// Requests are routed by host name.
+	require.Eventually(t, func() bool {
+		code, _ := get("http://upstream-1.virtual.consul/a")
+		return code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	code, body := get("http://upstream-1.virtual.consul/a")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, snis[0]+" upstream-1.virtual.consul /a", body)
+	code, body = get("http://v2.upstream-2.default.default.dc1:8080/b")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, snis[1]+" v2.upstream-2.default.default.dc1:8080 /b", body)
+
+	// Unknown hosts are rejected.
+	code, _ = get("http://upstream-3.virtual.consul/")
+	require.Equal(t, http.StatusBadGateway, code)
+
+	// CONNECT requests are tunneled to the upstream.
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = fmt.Fprint(conn, "CONNECT V2.Upstream-2.virtual.consul:443 HTTP/1.1\r\nHost: V2.Upstream-2.virtual.consul:443\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = fmt.Fprint(conn, "GET /c HTTP/1.1\r\nHost: upstream\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	tunneled, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, snis[1]+" upstream /c", string(tunneled))
+}
+
+func TestExtension_DNS(t *testing.T) {
+
+	// The recursor answers every query with a fixed address.
+	recursor := &dns.Server{Addr: "127.0.0.1:0", Net: "udp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		w.WriteMsg(resp)
+	})}
+	started := make(chan struct{})
+	recursor.NotifyStartedFunc = func() { close(started) }
+	go recursor.ListenAndServe()
+	<-started
+	t.Cleanup(func() { recursor.Shutdown() })
+
+	dnsPort := freePort(t)
+	upstreams := fmt.Sprintf("upstream-1:%d;service=upstream-2,subset=v2,port=%d,bind=127.0.0.2;service=upstream-3,socket=api.sock",
+		freePort(t), freePort(t))
+	cfg := testConfig(&MockStore{data: generateExtensionData(t, "test", testTrustDomain)}, "mesh.gateway.consul:8443", upstreams)
+	cfg.SocketDir = t.TempDir()
+	cfg.DNSPort = dnsPort
+	cfg.DNSRecursors = []string{recursor.PacketConn.LocalAddr().String()}
+
+	startExtension(t, cfg)
+
+	dnsAddr := fmt.Sprintf("127.0.0.1:%d", dnsPort)
+	query := func(network, name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		resp, _, err := (&dns.Client{Net: network}).Exchange(req, dnsAddr)
+		require.NoError(t, err)
+		return resp
+	}
+	answers := func(resp *dns.Msg) []string {
+		var ips []string
+		for _, rr := range resp.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+		return ips
+	}
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", dnsAddr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Upstream names resolve to the addresses of their listeners over UDP and TCP.
+	for _, network := range []string{"udp", "tcp"} {
+		resp := query(network, "upstream-1.virtual.consul.", dns.TypeA)
+		require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		require.Equal(t, []string{"127.0.0.1"}, answers(resp))
+	}
+	resp := query("udp", "V2.Upstream-2.default.default.dc1.", dns.TypeA)
+	require.Equal(t, []string{"127.0.0.2"}, answers(resp))
+
+	// Upstreams have no IPv6 addresses and socket upstreams have no addresses.
+	resp = query("udp", "upstream-1.virtual.consul.", dns.TypeAAAA)
+	require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Empty(t, resp.Answer)
+	resp = query("udp", "upstream-3.virtual.consul.", dns.TypeA)
+	require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Empty(t, resp.Answer)
+
+	// Other names are forwarded to the recursors.
+	resp = query("udp", "example.com.", dns.TypeA)
+	require.Equal(t, []string{"192.0.2.1"}, answers(resp))
+}
+
+func TestExtension_HTTPUpstream(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port1 := freePort(t)
+	port2 := freePort(t)
+
+	upstream, err := structs.ParseUpstream(fmt.Sprintf("service=upstream-1,port=%d", port1))
+	require.NoError(t, err)
+	upstream.TrustDomain = testTrustDomain
+
+	// The gateway only accepts connections for upstream-1.
+	var requests atomic.Int32
+	gateway := newHTTPGateway(t, ca, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		switch r.URL.Path {
+		case "/unavailable":
+			// Fail the first two requests.
+			if n <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/slow":
+			time.Sleep(time.Second)
+		}
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}), upstream.SNI())
+
+	upstreams := fmt.Sprintf("service=upstream-1,port=%d,protocol=http,timeout=250ms,retries=2;service=upstream-2,port=%d,protocol=http,retries=1",
+		port1, port2)
+	proxyPort := freePort(t)
+	cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, gateway, upstreams)
+	cfg.HTTPProxyPort = proxyPort
+
+	startExtension(t, cfg)
+
+	do := func(method string, port int, path string) (*http.Response, string) {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d%s", port, path), strings.NewReader("body"))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port1))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Idempotent requests are retried, with their body, when the upstream is unavailable.
+	requests.Store(0)
+	resp, body := do(http.MethodPut, port1, "/unavailable")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "PUT body", body)
+	require.Equal(t, int32(3), requests.Load())
+
+	// Other requests are not retried.
+	requests.Store(0)
+	resp, _ = do(http.MethodPost, port1, "/unavailable")
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, int32(1), requests.Load())
+
+	// Requests that exceed the timeout fail with 504 Gateway Timeout.
+	resp, _ = do(http.MethodGet, port1, "/slow")
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	require.Equal(t, "request to upstream upstream-1 timed out after 250ms", resp.Header.Get("X-Consul-Lambda-Error"))
+
+	// Requests that cannot connect to the upstream fail with 502 Bad Gateway.
+	resp, _ = do(http.MethodGet, port2, "/")
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("X-Consul-Lambda-Error"), "failed to connect to upstream upstream-2")
+
+	// Requests through the HTTP proxy use the upstream's retries and timeout.
+	proxyURL, err := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", proxyPort))
+	require.NoError(t, err)
+	proxyClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	requests.Store(0)
+	resp, err = proxyClient.Get("http://upstream-1.virtual.consul/unavailable")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int32(3), requests.Load())
+
+	resp, err = proxyClient.Get("http://upstream-1.virtual.consul/slow")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	require.Equal(t, "request to upstream upstream-1 timed out after 250ms", resp.Header.Get("X-Consul-Lambda-Error"))
+}
+
+func TestExtension_PeerNotFound(t *testing.T) {
+
+	ca := generateTestCA(t, testTrustDomain)
+	port := freePort(t)
+
+	// The extension data does not contain the peer of the upstream.
+	upstreams := fmt.Sprintf("service=upstream-1,peer=peer-1,port=%d,protocol=http", port)
+	cfg := testConfig(&MockStore{data: ca.extensionData(t, "test", 1)}, newMockGateway(t).addr(), upstreams)
+
+	startExtension(t, cfg)
+
+	// Connections to the upstream are rejected with an explicit error.
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("X-Consul-Lambda-Error"), "cluster peer not found in extension data: peer-1")
+}
+
+const testTrustDomain = "1e6de438-c2bd-e632-0ce1-c0fa58607a45.consul"
+
+// testConfig returns the configuration shared by the tests. The extension proxies the given upstreams through the
+// mesh gateway and reads its extension data from the store.
+func testConfig(store ext.ParamGetter, gateway string, upstreams ...string) *ext.Config {
+	return &ext.Config{
+		MeshGatewayURIs:     []string{gateway},
+		ExtensionDataPrefix: "test",
+		ServiceName:         "lambda-function",
+		ServiceUpstreams:    upstreams,
+		Store:               store,
+		Events:              &MockInvoker{invokes: make(chan chan struct{})},
+		Logger:              hclog.NewNullLogger(),
+		RefreshFrequency:    time.Hour,
+		ProxyTimeout:        time.Second,
+	}
+}
+
+// startExtension starts the extension until the test completes. The test fails if the extension returns an error.
+func startExtension(t testing.TB, cfg *ext.Config) *ext.Extension {
+	e := ext.NewExtension(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- e.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Errorf("extension failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("extension did not stop")
+		}
+	})
+	return e
 }
 
 type MockParamGetter struct {
@@ -71,6 +842,7 @@ type MockParamGetter struct {
 	wg            *sync.WaitGroup
 	path          string
 	extensionData []string
+	errs          []error
 	idx           int
 }
 
@@ -79,6 +851,10 @@ func (m *MockParamGetter) Get(_ context.Context, key string) (string, error) {
 	require.Equal(m.t, m.path, key)
 
 	ed := m.extensionData[m.idx]
+	var err error
+	if m.idx < len(m.errs) {
+		err = m.errs[m.idx]
+	}
 
 	// If the expected number of calls has been reached end the test.
 	m.idx++
@@ -87,7 +863,42 @@ func (m *MockParamGetter) Get(_ context.Context, key string) (string, error) {
 		defer m.wg.Done()
 	}
 
-	return ed, nil
+	return ed, err
+}
+
+type MockStore struct {
+	mu       sync.Mutex
+	data     string
+	notFound bool
+	gets     int
+}
+
+func (m *MockStore) Get(_ context.Context, _ string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gets++
+	if m.notFound {
+		return "", client.ErrNotFound
+	}
+	return m.data, nil
+}
+
+func (m *MockStore) getCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gets
+}
+
+func (m *MockStore) setData(data string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = data
+}
+
+func (m *MockStore) setNotFound(notFound bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notFound = notFound
 }
 
 type MockEventProcessor struct {
@@ -98,37 +909,247 @@ func (m MockEventProcessor) Register(_ context.Context, _ interface{}) error {
 	return nil
 }
 
-func (m MockEventProcessor) ProcessEvents(ctx context.Context) error {
+// ProcessEvents invokes the function every millisecond until the context is cancelled.
+func (m MockEventProcessor) ProcessEvents(ctx context.Context, onInvoke func(context.Context)) error {
 	m.Wait.Add(1)
 	defer m.Wait.Done()
-	<-ctx.Done()
-	return nil
+	invoke := time.NewTicker(time.Millisecond)
+	defer invoke.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-invoke.C:
+			onInvoke(ctx)
+		}
+	}
 }
 
-func generateExtensionData(t *testing.T, name, trustDomain string) string {
+func generateExtensionData(t testing.TB, name, trustDomain string) string {
+	return generateExtensionDataWithDays(t, name, trustDomain, 1)
+}
+
+// generateExtensionDataWithDays generates extension data with a leaf certificate that is valid for the given
+// number of days. A certificate that is valid for 0 days is already expired.
+func generateExtensionDataWithDays(t testing.TB, name, trustDomain string, days int) string {
+	return generateTestCA(t, trustDomain).extensionData(t, name, days)
+}
+
+// testCA is a CA for signing the certificates used in tests.
+type testCA struct {
+	certPEM     string
+	signer      crypto.Signer
+	trustDomain string
+}
+
+func generateTestCA(t testing.TB, trustDomain string) testCA {
 	ca, caKey, err := tlsutil.GenerateCA(tlsutil.CAOpts{Domain: trustDomain})
 	require.NoError(t, err)
 
 	signer, err := tlsutil.ParseSigner(caKey)
 	require.NoError(t, err)
+	return testCA{certPEM: ca, signer: signer, trustDomain: trustDomain}
+}
 
+// cert returns a PEM encoded certificate and private key signed by the CA.
+func (ca testCA) cert(t testing.TB, name string, days int, dnsNames ...string) (string, string) {
 	cert, pk, err := tlsutil.GenerateCert(tlsutil.CertOpts{
-		CA:          ca,
-		Signer:      signer,
+		CA:          ca.certPEM,
+		Signer:      ca.signer,
 		Name:        name,
-		DNSNames:    []string{fmt.Sprintf("%s.%s", name, trustDomain)},
+		Days:        days,
+		DNSNames:    dnsNames,
 		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
 	})
 	require.NoError(t, err)
+	return cert, pk
+}
+
+// extensionData returns extension data with a leaf certificate for the named service that is valid for
+// the given number of days and the given mesh gateways.
+func (ca testCA) extensionData(t testing.TB, name string, days int, meshGateways ...structs.MeshGateway) string {
+	cert, pk := ca.cert(t, name, days, fmt.Sprintf("%s.%s", name, ca.trustDomain))
 
 	ed := structs.ExtensionData{
 		PrivateKeyPEM: pk,
 		CertPEM:       cert,
-		RootCertPEM:   ca,
-		TrustDomain:   trustDomain,
+		RootCertPEM:   ca.certPEM,
+		TrustDomain:   ca.trustDomain,
+		MeshGateways:  meshGateways,
 	}
 
 	edJSON, err := json.Marshal(ed)
 	require.NoError(t, err)
 	return string(edJSON)
 }
+
+// MockInvoker invokes the function each time invoke is called.
+type MockInvoker struct {
+	invokes chan chan struct{}
+}
+
+func (m *MockInvoker) Register(_ context.Context, _ interface{}) error {
+	return nil
+}
+
+func (m *MockInvoker) ProcessEvents(ctx context.Context, onInvoke func(context.Context)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case done := <-m.invokes:
+			onInvoke(ctx)
+			close(done)
+		}
+	}
+}
+
+// invoke blocks until the invocation has been processed.
+func (m *MockInvoker) invoke() {
+	done := make(chan struct{})
+	m.invokes <- done
+	<-done
+}
+
+// mockGateway is a mesh gateway that records each connection and then closes it.
+type mockGateway struct {
+	listener net.Listener
+	accepted chan struct{}
+}
+
+func newMockGateway(t *testing.T) *mockGateway {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	g := &mockGateway{listener: l, accepted: make(chan struct{}, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			g.accepted <- struct{}{}
+			conn.Close()
+		}
+	}()
+	return g
+}
+
+func (g *mockGateway) addr() string {
+	return g.listener.Addr().String()
+}
+
+// proxied makes a connection to the upstream listening on the given port and reports whether
+// it was proxied to the mesh gateway.
+func (g *mockGateway) proxied(port int) bool {
+	for len(g.accepted) > 0 {
+		<-g.accepted
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, _ = io.ReadAll(conn)
+	return len(g.accepted) > 0
+}
+
+// tlsGateway is a mesh gateway that terminates mTLS connections and echoes the data that it receives.
+type tlsGateway struct {
+	listener net.Listener
+	conns    atomic.Int32
+}
+
+// newTLSGateway starts a gateway for the SNI that echoes the data that it receives.
+func newTLSGateway(t testing.TB, ca testCA, sni string, disableSessionTickets bool) *tlsGateway {
+	l := newGatewayListener(t, ca, disableSessionTickets, sni)
+	g := &tlsGateway{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			g.conns.Add(1)
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return g
+}
+
+// newHTTPGateway starts a gateway for the SNIs that serves HTTP requests with the handler.
+// It returns the address of the gateway.
+func newHTTPGateway(t testing.TB, ca testCA, handler http.Handler, snis ...string) string {
+	l := newGatewayListener(t, ca, false, snis...)
+	srv := &http.Server{Handler: handler, ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+// newGatewayListener returns a TLS listener that presents a certificate for the SNIs signed by the CA.
+// Like a mesh gateway it rejects connections for any other SNI.
+func newGatewayListener(t testing.TB, ca testCA, disableSessionTickets bool, snis ...string) net.Listener {
+	cert, key := ca.cert(t, "mesh-gateway", 1, snis...)
+	keyPair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM([]byte(ca.certPEM))
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates:           []tls.Certificate{keyPair},
+		ClientAuth:             tls.RequireAndVerifyClientCert,
+		ClientCAs:              clientCAs,
+		SessionTicketsDisabled: disableSessionTickets,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if !slices.Contains(snis, hello.ServerName) {
+				return nil, fmt.Errorf("no route for SNI %q", hello.ServerName)
+			}
+			return nil, nil
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func (g *tlsGateway) addr() string {
+	return g.listener.Addr().String()
+}
+
+// connCount returns the number of connections that the gateway has accepted.
+func (g *tlsGateway) connCount() int {
+	return int(g.conns.Load())
+}
+
+// echo sends a byte to the upstream listening on the given port and waits for it to be echoed back.
+func echo(port int) error {
+	return echoAddr(fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+// echoAddr sends a byte to the upstream listening on the given address and waits for it to be echoed back.
+func echoAddr(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("a")); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 1))
+	return err
+}
+
+// freePort returns a port that is free to listen on.
+func freePort(t testing.TB) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,265 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// minGatewayBackoff and maxGatewayBackoff bound how long a mesh gateway is skipped after failed connections.
+	minGatewayBackoff = time.Second
+	maxGatewayBackoff = 30 * time.Second
+
+	// gatewayResolveInterval is how long the resolved addresses of the mesh gateways are cached.
+	gatewayResolveInterval = 30 * time.Second
+
+	// gatewayResolveTimeout bounds how long resolving the addresses of the mesh gateways can take.
+	gatewayResolveTimeout = 2 * time.Second
+)
+
+// gateways selects the mesh gateway to connect to from a set of gateway addresses.
+//
+// Each address is a host and port. The addresses discovered from the extension data take precedence
+// over the configured addresses. Hosts that are DNS names are resolved and every address that
+// they resolve to is treated as a separate gateway. Gateways are selected in round-robin order.
+// A gateway that fails is skipped for an exponentially increasing backoff period so that
+// connections fail over to the remaining gateways.
+type gateways struct {
+	addrs  []string
+	lookup func(ctx context.Context, host string) ([]string, error)
+	logger hclog.Logger
+
+	// mu guards the fields below. It is not held while resolving DNS names.
+	mu           sync.Mutex
+	discovered   []string
+	next         int
+	resolved     []string
+	resolvedAt   time.Time
+	lastResolved map[string][]string
+	health       map[string]*gatewayHealth
+}
+
+// gatewayHealth tracks the failures of a single mesh gateway.
+type gatewayHealth struct {
+	failures int
+	retryAt  time.Time
+}
+
+// newGateways returns the set of mesh gateways for the given host:port addresses.
+// The addresses may be empty if the gateways are discovered from the extension data.
+func newGateways(addrs []string, logger hclog.Logger) (*gateways, error) {
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid mesh gateway address %q: %w", addr, err)
+		}
+	}
+	return &gateways{
+		addrs:        addrs,
+		lookup:       net.DefaultResolver.LookupHost,
+		logger:       logger,
+		lastResolved: make(map[string][]string),
+		health:       make(map[string]*gatewayHealth),
+	}, nil
+}
+
+// dial connects to a mesh gateway using the given dial func.
+// It tries each gateway in turn until a connection succeeds and returns the last error if none do.
+func (g *gateways) dial(ctx context.Context, dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	addrs := g.candidates(ctx)
+	if len(addrs) == 0 {
+		return nil, errors.New("no mesh gateway addresses are configured or discovered")
+	}
+	var err error
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dial(addr)
+		if err == nil {
+			g.succeeded(addr)
+			return conn, nil
+		}
+		g.failed(addr, err)
+	}
+	return nil, fmt.Errorf("failed to connect to any mesh gateway: %w", err)
+}
+
+// candidates returns the gateways in the order that they should be tried.
+// The gateways that are not backing off come first, starting with the next gateway in the
+// round-robin rotation, followed by the gateways that are backing off, soonest retry first.
+func (g *gateways) candidates(ctx context.Context) []string {
+	addrs := g.resolve(ctx)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(addrs) == 0 {
+		return nil
+	}
+	start := g.next % len(addrs)
+	g.next++
+
+	now := time.Now()
+	healthy := make([]string, 0, len(addrs))
+	var backoff []string
+	for i := range addrs {
+		addr := addrs[(start+i)%len(addrs)]
+		if h, ok := g.health[addr]; ok && now.Before(h.retryAt) {
+			backoff = append(backoff, addr)
+			continue
+		}
+		healthy = append(healthy, addr)
+	}
+	sort.SliceStable(backoff, func(i, j int) bool {
+		return g.health[backoff[i]].retryAt.Before(g.health[backoff[j]].retryAt)
+	})
+	return append(healthy, backoff...)
+}
+
+// resolve returns the addresses of all the gateways, resolving DNS names to their IP addresses.
+// The result is cached for gatewayResolveInterval. If a name cannot be resolved the addresses it
+// previously resolved to are used, or the name itself if it has never been resolved.
+// The names are resolved without holding mu so that a slow DNS server does not block other dials.
+func (g *gateways) resolve(ctx context.Context) []string {
+	g.mu.Lock()
+	if g.resolved != nil && time.Since(g.resolvedAt) < gatewayResolveInterval {
+		resolved := g.resolved
+		g.mu.Unlock()
+		return resolved
+	}
+	addrs := g.current()
+	lastResolved := make(map[string][]string, len(addrs))
+	for _, addr := range addrs {
+		lastResolved[addr] = g.lastResolved[addr]
+	}
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, gatewayResolveTimeout)
+	defer cancel()
+
+	resolved := []string{}
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		hosts := []string{host}
+		if net.ParseIP(host) == nil {
+			ips, err := g.lookup(ctx, host)
+			switch {
+			case err == nil && len(ips) > 0:
+				hosts = ips
+				lastResolved[addr] = ips
+			case len(lastResolved[addr]) > 0:
+				g.logger.Warn("failed to resolve mesh gateway address; using previous addresses", "address", addr, "error", err)
+				hosts = lastResolved[addr]
+			default:
+				g.logger.Warn("failed to resolve mesh gateway address", "address", addr, "error", err)
+			}
+		}
+		for _, h := range hosts {
+			a := net.JoinHostPort(h, port)
+			if !seen[a] {
+				seen[a] = true
+				resolved = append(resolved, a)
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for addr, ips := range lastResolved {
+		if len(ips) > 0 {
+			g.lastResolved[addr] = ips
+		}
+	}
+	// Don't cache the result if the discovered addresses changed while resolving.
+	if slices.Equal(addrs, g.current()) {
+		g.resolved = resolved
+		g.resolvedAt = time.Now()
+	}
+	return resolved
+}
+
+// current returns the discovered addresses, or the configured addresses if there are none.
+// It must be called with mu held.
+func (g *gateways) current() []string {
+	if len(g.discovered) > 0 {
+		return g.discovered
+	}
+	return g.addrs
+}
+
+// setDiscovered sets the gateway addresses discovered from the extension data.
+// Invalid addresses are ignored. If there are no valid addresses the configured addresses are used.
+func (g *gateways) setDiscovered(addrs []string) {
+	var discovered []string
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			g.logger.Warn("ignoring invalid mesh gateway address", "address", addr, "error", err)
+			continue
+		}
+		discovered = append(discovered, addr)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if slices.Equal(g.discovered, discovered) {
+		return
+	}
+	g.discovered = discovered
+	g.resolved = nil
+	g.logger.Debug("updated mesh gateway addresses", "addresses", discovered)
+}
+
+// succeeded clears the failures of the gateway.
+func (g *gateways) succeeded(addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.health, addr)
+}
+
+// failed records a failure of the gateway and backs it off if the gateway is unreachable.
+// Other errors, such as a certificate that fails verification or an SNI that the gateway does not
+// route, are caused by the configuration rather than the gateway so it is not backed off.
+func (g *gateways) failed(addr string, err error) {
+	if !unreachable(err) {
+		g.logger.Warn("failed to connect to mesh gateway", "address", addr, "error", err)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	h, ok := g.health[addr]
+	if !ok {
+		h = &gatewayHealth{}
+		g.health[addr] = h
+	}
+	h.failures++
+
+	backoff := minGatewayBackoff << (h.failures - 1)
+	if backoff > maxGatewayBackoff || backoff <= 0 {
+		backoff = maxGatewayBackoff
+	}
+	h.retryAt = time.Now().Add(backoff)
+	g.logger.Warn("failed to connect to mesh gateway", "address", addr, "error", err, "backoff", backoff)
+}
+
+// unreachable reports whether the error means that the gateway could not be reached, either because
+// the connection could not be established or because the connection or the TLS handshake timed out.
+func unreachable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,129 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// errRefused is the error returned when a connection to a gateway is refused.
+var errRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+func TestGatewaysCandidates(t *testing.T) {
+	g, err := newGateways([]string{"10.0.0.1:8443", "10.0.0.2:8443", "10.0.0.3:8443"}, hclog.NewNullLogger())
+	require.NoError(t, err)
+
+	// Gateways are tried in round-robin order.
+	require.Equal(t, []string{"10.0.0.1:8443", "10.0.0.2:8443", "10.0.0.3:8443"}, g.candidates(context.Background()))
+	require.Equal(t, []string{"10.0.0.2:8443", "10.0.0.3:8443", "10.0.0.1:8443"}, g.candidates(context.Background()))
+
+	// Gateways that are backing off are tried last, soonest retry first.
+	g.failed("10.0.0.1:8443", errRefused)
+	g.failed("10.0.0.1:8443", errRefused)
+	g.failed("10.0.0.2:8443", errRefused)
+	require.Equal(t, []string{"10.0.0.3:8443", "10.0.0.2:8443", "10.0.0.1:8443"}, g.candidates(context.Background()))
+
+	// A gateway that succeeds is no longer backed off.
+	g.succeeded("10.0.0.1:8443")
+	require.Equal(t, []string{"10.0.0.1:8443", "10.0.0.3:8443", "10.0.0.2:8443"}, g.candidates(context.Background()))
+}
+
+func TestGatewaysFailed(t *testing.T) {
+	const addr = "10.0.0.1:8443"
+	g, err := newGateways([]string{addr}, hclog.NewNullLogger())
+	require.NoError(t, err)
+
+	// The backoff doubles with each failure up to the maximum.
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second} {
+		g.failed(addr, errRefused)
+		require.WithinDuration(t, time.Now().Add(backoff), g.health[addr].retryAt, 100*time.Millisecond)
+	}
+
+	g.succeeded(addr)
+	require.NotContains(t, g.health, addr)
+
+	// Gateways are not backed off for errors that are caused by the configuration.
+	g.failed(addr, &tls.CertificateVerificationError{Err: errors.New("unknown authority")})
+	g.failed(addr, tls.AlertError(112))
+	require.NotContains(t, g.health, addr)
+
+	// Gateways are backed off for timeouts.
+	g.failed(addr, &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded})
+	require.Contains(t, g.health, addr)
+}
+
+func TestGatewaysResolve(t *testing.T) {
+	lookups := make(map[string]int)
+	results := map[string][]string{
+		"gateway.consul":    {"10.0.0.1", "10.0.0.2"},
+		"discovered.consul": {"10.0.1.1"},
+	}
+	g, err := newGateways([]string{"gateway.consul:8443", "10.0.0.2:8443", "unknown.consul:8443"}, hclog.NewNullLogger())
+	require.NoError(t, err)
+	g.lookup = func(ctx context.Context, host string) ([]string, error) {
+		_, ok := ctx.Deadline()
+		require.True(t, ok)
+		lookups[host]++
+		if ips, ok := results[host]; ok {
+			return ips, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	resolve := func() []string { return g.resolve(context.Background()) }
+
+	// Names are resolved to every address and duplicates are removed.
+	// A name that has never been resolved is used as is.
+	require.Equal(t, []string{"10.0.0.1:8443", "10.0.0.2:8443", "unknown.consul:8443"}, resolve())
+
+	// The result is cached.
+	require.Equal(t, []string{"10.0.0.1:8443", "10.0.0.2:8443", "unknown.consul:8443"}, resolve())
+	require.Equal(t, 1, lookups["gateway.consul"])
+
+	// The previous addresses are used when a name cannot be resolved.
+	delete(results, "gateway.consul")
+	g.resolvedAt = time.Time{}
+	require.Equal(t, []string{"10.0.0.1:8443", "10.0.0.2:8443", "unknown.consul:8443"}, resolve())
+	require.Equal(t, 2, lookups["gateway.consul"])
+
+	// The discovered addresses take precedence over the configured addresses.
+	g.setDiscovered([]string{"discovered.consul:443", "invalid"})
+	require.Equal(t, []string{"10.0.1.1:443"}, resolve())
+
+	// The configured addresses are used when no addresses are discovered.
+	g.setDiscovered(nil)
+	require.Equal(t, []string{"10.0.0.1:8443", "10.0.0.2:8443", "unknown.consul:8443"}, resolve())
+}
+
+func TestGatewaysResolveUnlocked(t *testing.T) {
+	g, err := newGateways([]string{"gateway.consul:8443"}, hclog.NewNullLogger())
+	require.NoError(t, err)
+	resolving, release := make(chan struct{}), make(chan struct{})
+	g.lookup = func(context.Context, string) ([]string, error) {
+		close(resolving)
+		<-release
+		return []string{"10.0.0.1"}, nil
+	}
+	done := make(chan []string)
+	go func() { done <- g.resolve(context.Background()) }()
+
+	// The gateways can be updated while a name is being resolved.
+	<-resolving
+	g.failed("10.0.0.1:8443", errRefused)
+	g.setDiscovered([]string{"10.0.1.1:443"})
+	close(release)
+	require.Equal(t, []string{"10.0.0.1:8443"}, <-done)
+
+	// The result is not cached because the addresses changed while resolving.
+	require.Equal(t, []string{"10.0.1.1:443"}, g.resolve(context.Background()))
+}
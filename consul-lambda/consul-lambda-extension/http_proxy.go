@@ -0,0 +1,227 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/proxy"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+// httpProxy is an HTTP forward proxy that routes requests to the upstreams by host name so that
+// functions can set HTTP_PROXY and call the upstreams by name instead of by port.
+//
+// CONNECT requests are tunneled to the upstream and requests in absolute form, such as
+// `GET http://api.virtual.consul/path`, are forwarded to it. Requests to upstreams that use the
+// http protocol are forwarded by the upstream's httpUpstream so that its timeout and retries apply.
+// The host names of each upstream are given by structs.Service.Hostnames.
+type httpProxy struct {
+	upstreams     map[string]*structs.Service
+	httpUpstreams map[*structs.Service]*httpUpstream
+	dial          func(upstream *structs.Service) (net.Conn, error)
+	logger        hclog.Logger
+
+	server    *http.Server
+	transport *http.Transport
+	forward   *httputil.ReverseProxy
+
+	// tunnelsLock guards access to the tunnels field.
+	tunnelsLock sync.Mutex
+	tunnels     map[*proxy.Conn]struct{}
+}
+
+// newHTTPProxy returns an HTTP proxy for the upstreams that connects to them using dial.
+// Requests to the upstreams served by httpUpstreams are forwarded by them instead.
+func newHTTPProxy(upstreams []*structs.Service, httpUpstreams []*httpUpstream, dial func(*structs.Service) (net.Conn, error), logger hclog.Logger) *httpProxy {
+	p := &httpProxy{
+		upstreams:     upstreamsByHost(upstreams, logger),
+		httpUpstreams: make(map[*structs.Service]*httpUpstream, len(httpUpstreams)),
+		dial:          dial,
+		logger:        logger,
+		tunnels:       make(map[*proxy.Conn]struct{}),
+	}
+	for _, u := range httpUpstreams {
+		p.httpUpstreams[u.upstream] = u
+	}
+
+	p.transport = &http.Transport{
+		DialContext: func(_ context.Context, _, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			upstream := p.lookup(host)
+			if upstream == nil {
+				return nil, fmt.Errorf("no upstream for host %s", host)
+			}
+			return p.dial(upstream)
+		},
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	p.forward = &httputil.ReverseProxy{
+		// The incoming request is already addressed to the upstream.
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: p.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.logger.Warn("failed to forward request to upstream", "host", r.URL.Host, "error", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	return p
+}
+
+// start listens on addr and serves the proxy in a separate go routine that reports any errors
+// to the caller via errChan.
+func (p *httpProxy) start(addr string, errChan chan error) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP proxy: %w", err)
+	}
+	p.logger.Info("HTTP proxy ready", "address", addr)
+	go func() {
+		if err := p.server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			errChan <- fmt.Errorf("failed to serve HTTP proxy: %w", err)
+		}
+	}()
+	return nil
+}
+
+// close shuts down the proxy and closes all active connections.
+func (p *httpProxy) close() {
+	p.server.Close()
+	p.closeConns()
+}
+
+// closeConns closes the connections to the upstreams but keeps the proxy running.
+func (p *httpProxy) closeConns() {
+	p.transport.CloseIdleConnections()
+
+	p.tunnelsLock.Lock()
+	defer p.tunnelsLock.Unlock()
+	for conn := range p.tunnels {
+		conn.Close()
+	}
+}
+
+// ServeHTTP routes the request to the upstream that matches its host.
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	if r.URL.Scheme != "http" || r.URL.Host == "" {
+		http.Error(w, "request must be in absolute form, for example GET http://api.virtual.consul/", http.StatusBadRequest)
+		return
+	}
+	upstream := p.lookup(r.URL.Hostname())
+	if upstream == nil {
+		http.Error(w, fmt.Sprintf("no upstream for host %s", r.URL.Hostname()), http.StatusBadGateway)
+		return
+	}
+	if u, ok := p.httpUpstreams[upstream]; ok {
+		u.ServeHTTP(w, r)
+		return
+	}
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel connects the client to the upstream and copies bytes between them.
+func (p *httpProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Hostname()
+	upstream := p.lookup(host)
+	if upstream == nil {
+		http.Error(w, fmt.Sprintf("no upstream for host %s", host), http.StatusBadGateway)
+		return
+	}
+
+	dst, err := p.dial(upstream)
+	if err != nil {
+		p.logger.Warn("failed to connect to upstream", "host", host, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		dst.Close()
+		http.Error(w, "connection does not support tunneling", http.StatusInternalServerError)
+		return
+	}
+	src, buf, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		p.logger.Warn("failed to hijack connection", "error", err)
+		return
+	}
+	if _, err := src.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		src.Close()
+		dst.Close()
+		return
+	}
+
+	conn := proxy.NewConn(&bufferedConn{Conn: src, r: buf.Reader}, dst)
+	p.tunnelsLock.Lock()
+	p.tunnels[conn] = struct{}{}
+	p.tunnelsLock.Unlock()
+	defer func() {
+		p.tunnelsLock.Lock()
+		delete(p.tunnels, conn)
+		p.tunnelsLock.Unlock()
+	}()
+
+	if err := conn.CopyBytes(); err != nil {
+		p.logger.Debug("tunnel closed", "host", host, "error", err)
+	}
+}
+
+// lookup returns the upstream for the host name or nil if there is none.
+func (p *httpProxy) lookup(host string) *structs.Service {
+	return p.upstreams[normalizeHost(host)]
+}
+
+// upstreamsByHost returns the upstreams keyed by each of their host names.
+// If a host name matches more than one upstream the first upstream is used.
+func upstreamsByHost(upstreams []*structs.Service, logger hclog.Logger) map[string]*structs.Service {
+	hosts := make(map[string]*structs.Service)
+	for _, upstream := range upstreams {
+		for _, host := range upstream.Hostnames() {
+			if other, ok := hosts[host]; ok {
+				logger.Warn("host name matches more than one upstream; using the first", "host", host, "upstream", other.String())
+				continue
+			}
+			hosts[host] = upstream
+		}
+	}
+	return hosts
+}
+
+// normalizeHost returns the host name in the form returned by structs.Service.Hostnames.
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// bufferedConn is a net.Conn that first returns the data that was buffered while reading the CONNECT request.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,250 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+const (
+	// errorReasonHeader is the response header that explains why a request to an HTTP upstream failed.
+	errorReasonHeader = "X-Consul-Lambda-Error"
+
+	// maxRetryBodySize is the largest request body that is buffered so that the request can be retried.
+	maxRetryBodySize = 1 << 20
+
+	// retryBackoff is the delay before the first retry of a request. It increases linearly with each retry.
+	retryBackoff = 25 * time.Millisecond
+)
+
+// httpUpstream serves an upstream that uses the http protocol.
+//
+// Unlike the TCP proxy, which copies bytes between the function and the upstream, it parses the
+// requests from the function so that it can apply the upstream's request timeout, retry
+// idempotent requests when the connection to the upstream fails or the upstream responds with
+// 503 Service Unavailable, and respond with a well-formed 502 Bad Gateway or 504 Gateway Timeout
+// and a reason header instead of resetting the connection.
+type httpUpstream struct {
+	upstream *structs.Service
+	listen   func() (net.Listener, error)
+	logger   hclog.Logger
+
+	server    *http.Server
+	transport *http.Transport
+	forward   *httputil.ReverseProxy
+}
+
+// newHTTPUpstream returns an HTTP upstream that listens using listen and connects to the upstream using dial.
+func newHTTPUpstream(upstream *structs.Service, listen func() (net.Listener, error), dial func() (net.Conn, error), logger hclog.Logger) *httpUpstream {
+	u := &httpUpstream{
+		upstream: upstream,
+		listen:   listen,
+		logger:   logger,
+	}
+	u.transport = &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			conn, err := dial()
+			if err != nil {
+				return nil, &dialError{err: err}
+			}
+			return conn, nil
+		},
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	u.forward = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// The connection is routed by SNI so the URL only needs to be valid.
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = upstream.Name
+		},
+		Transport:    &retryTransport{base: u.transport, retries: upstream.Retries, logger: logger},
+		ErrorHandler: u.handleError,
+	}
+	u.server = &http.Server{Handler: u, ReadHeaderTimeout: 10 * time.Second}
+	return u
+}
+
+// start listens for requests from the function and serves them in a separate go routine that
+// reports any errors to the caller via errChan.
+func (u *httpUpstream) start(errChan chan error) error {
+	l, err := u.listen()
+	if err != nil {
+		return fmt.Errorf("failed to listen for upstream %s: %w", u.upstream.Name, err)
+	}
+	go func() {
+		if err := u.server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			errChan <- fmt.Errorf("failed to serve upstream %s: %w", u.upstream.Name, err)
+		}
+	}()
+	return nil
+}
+
+// close stops serving requests and closes all connections.
+func (u *httpUpstream) close() {
+	u.server.Close()
+	u.closeConns()
+}
+
+// closeConns closes the idle connections to the upstream.
+func (u *httpUpstream) closeConns() {
+	u.transport.CloseIdleConnections()
+}
+
+// ServeHTTP forwards the request to the upstream within the upstream's request timeout.
+func (u *httpUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u.upstream.RequestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), u.upstream.RequestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	u.forward.ServeHTTP(w, r)
+}
+
+// handleError responds to a request that could not be forwarded to the upstream.
+func (u *httpUpstream) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	reason := fmt.Sprintf("request to upstream %s failed: %v", u.upstream.Name, err)
+	var dialErr *dialError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+		reason = fmt.Sprintf("request to upstream %s timed out after %s", u.upstream.Name, u.upstream.RequestTimeout)
+	case errors.As(err, &dialErr):
+		reason = fmt.Sprintf("failed to connect to upstream %s: %v", u.upstream.Name, dialErr.err)
+	}
+	u.logger.Warn("request to upstream failed", "upstream", u.upstream.Name, "method", r.Method, "path", r.URL.Path, "status", status, "error", err)
+
+	w.Header().Set(errorReasonHeader, reason)
+	http.Error(w, reason, status)
+}
+
+// dialError is returned when the connection to the upstream cannot be established.
+// The request was not sent so it is always safe to retry.
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
+// retryTransport retries idempotent requests when the connection to the upstream fails or the
+// upstream responds with 503 Service Unavailable.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	logger  hclog.Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.retries == 0 || !isIdempotent(req.Method) {
+		return t.base.RoundTrip(req)
+	}
+
+	// Buffer the body so that it can be sent again. A body that is too large to buffer, or whose
+	// length is unknown, can only be sent again if it was never read because the connection to
+	// the upstream failed, so 503 responses to those requests are not retried.
+	var unbuffered *unreadBody
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		if req.ContentLength < 0 || req.ContentLength > maxRetryBodySize {
+			unbuffered = &unreadBody{ReadCloser: req.Body}
+			req = req.Clone(req.Context())
+			req.Body = unbuffered
+		} else {
+			body, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(time.Duration(attempt) * retryBackoff):
+			}
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req = req.Clone(req.Context())
+				req.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		var dialErr *dialError
+		retry := false
+		switch {
+		case errors.As(err, &dialErr):
+			retry = unbuffered == nil || !unbuffered.read.Load()
+		case err == nil && resp.StatusCode == http.StatusServiceUnavailable:
+			retry = unbuffered == nil
+		}
+		if !retry || attempt >= t.retries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		t.logger.Debug("retrying request to upstream", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "error", err)
+	}
+}
+
+// unreadBody is a request body that is not buffered. It ignores Close until it has been read so that
+// the transport does not close it when the connection to the upstream fails and it can be sent by a
+// retry. The server closes the underlying body once the request has been served.
+type unreadBody struct {
+	io.ReadCloser
+	read atomic.Bool
+}
+
+func (b *unreadBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.ReadCloser.Read(p)
+}
+
+func (b *unreadBody) Close() error {
+	if !b.read.Load() {
+		return nil
+	}
+	return b.ReadCloser.Close()
+}
+
+// isIdempotent reports whether requests with the method can be safely retried.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+// roundTripFunc is an http.RoundTripper that records the body of each request.
+// Like http.Transport it closes the body without reading it when the request fails.
+type roundTripFunc struct {
+	bodies   []string
+	response func(attempt int) (*http.Response, error)
+}
+
+func (f *roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := f.response(len(f.bodies))
+	var body string
+	if req.Body != nil {
+		if err != nil {
+			req.Body.Close()
+		} else {
+			b, err := io.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			req.Body.Close()
+			body = string(b)
+		}
+	}
+	f.bodies = append(f.bodies, body)
+	return resp, err
+}
+
+func response(status int) *http.Response {
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}
+}
+
+func TestRetryTransport(t *testing.T) {
+	errDial := &dialError{err: errors.New("refused")}
+
+	cases := map[string]struct {
+		method    string
+		body      string
+		chunked   bool
+		responses []int
+		dialErrs  int
+		expStatus int
+		expErr    error
+		expCalls  int
+	}{
+		"retries 503 until the retries are exhausted": {
+			method:    http.MethodGet,
+			responses: []int{503, 503, 503},
+			expStatus: http.StatusServiceUnavailable,
+			expCalls:  3,
+		},
+		"retries 503 until it succeeds": {
+			method:    http.MethodGet,
+			responses: []int{503, 200},
+			expStatus: http.StatusOK,
+			expCalls:  2,
+		},
+		"retries dial errors": {
+			method:    http.MethodGet,
+			dialErrs:  1,
+			responses: []int{0, 200},
+			expStatus: http.StatusOK,
+			expCalls:  2,
+		},
+		"does not retry non-idempotent requests": {
+			method:    http.MethodPost,
+			body:      "body",
+			responses: []int{503},
+			expStatus: http.StatusServiceUnavailable,
+			expCalls:  1,
+		},
+		"resends the body": {
+			method:    http.MethodPut,
+			body:      "body",
+			responses: []int{503, 200},
+			expStatus: http.StatusOK,
+			expCalls:  2,
+		},
+		"retries dial errors for bodies that are not buffered": {
+			method:    http.MethodPut,
+			body:      "body",
+			chunked:   true,
+			dialErrs:  2,
+			responses: []int{0, 0, 200},
+			expStatus: http.StatusOK,
+			expCalls:  3,
+		},
+		"does not retry 503 for bodies that are not buffered": {
+			method:    http.MethodPut,
+			body:      "body",
+			chunked:   true,
+			responses: []int{503},
+			expStatus: http.StatusServiceUnavailable,
+			expCalls:  1,
+		},
+		"returns the dial error once the retries are exhausted": {
+			method:    http.MethodGet,
+			dialErrs:  3,
+			responses: []int{0, 0, 0},
+			expErr:    errDial,
+			expCalls:  3,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			base := &roundTripFunc{response: func(attempt int) (*http.Response, error) {
+				if attempt < c.dialErrs {
+					return nil, errDial
+				}
+				return response(c.responses[attempt]), nil
+			}}
+			rt := &retryTransport{base: base, retries: 2, logger: hclog.NewNullLogger()}
+
+			var body io.Reader
+			if c.body != "" {
+				body = io.NopCloser(strings.NewReader(c.body))
+			}
+			req, err := http.NewRequest(c.method, "http://upstream", body)
+			require.NoError(t, err)
+			req.GetBody = nil
+			if c.chunked {
+				req.ContentLength = -1
+			}
+
+			resp, err := rt.RoundTrip(req)
+			if c.expErr != nil {
+				require.ErrorIs(t, err, c.expErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c.expStatus, resp.StatusCode)
+			}
+			require.Len(t, base.bodies, c.expCalls)
+			for i, b := range base.bodies {
+				if i < c.dialErrs {
+					require.Empty(t, b)
+				} else {
+					require.Equal(t, c.body, b)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportCancel(t *testing.T) {
+	base := &roundTripFunc{response: func(int) (*http.Response, error) { return response(http.StatusServiceUnavailable), nil }}
+	rt := &retryTransport{base: base, retries: 2, logger: hclog.NewNullLogger()}
+
+	// The retries stop when the request is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://upstream", nil)
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, base.bodies, 1)
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete} {
+		require.True(t, isIdempotent(m), m)
+	}
+	for _, m := range []string{http.MethodPost, http.MethodPatch, http.MethodConnect} {
+		require.False(t, isIdempotent(m), m)
+	}
+}
+
+func TestHTTPUpstreamHandleError(t *testing.T) {
+	u := &httpUpstream{
+		upstream: &structs.Service{Name: "upstream-1", RequestTimeout: time.Second},
+		logger:   hclog.NewNullLogger(),
+	}
+
+	cases := map[string]struct {
+		err       error
+		expStatus int
+		expReason string
+	}{
+		"timeout": {
+			err:       context.DeadlineExceeded,
+			expStatus: http.StatusGatewayTimeout,
+			expReason: "request to upstream upstream-1 timed out after 1s",
+		},
+		"dial error": {
+			err:       &dialError{err: errors.New("refused")},
+			expStatus: http.StatusBadGateway,
+			expReason: "failed to connect to upstream upstream-1: refused",
+		},
+		"other error": {
+			err:       errors.New("connection reset"),
+			expStatus: http.StatusBadGateway,
+			expReason: "request to upstream upstream-1 failed: connection reset",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			u.handleError(w, httptest.NewRequest(http.MethodGet, "/", nil), c.err)
+			require.Equal(t, c.expStatus, w.Code)
+			require.Equal(t, c.expReason, w.Header().Get(errorReasonHeader))
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"time"
 )
 
 const (
@@ -53,6 +54,7 @@ type Tracing struct {
 type EventType string
 
 const (
+	Invoke   EventType = "INVOKE"
 	Shutdown EventType = "SHUTDOWN"
 )
 
@@ -66,12 +68,12 @@ func NewLambda() *Lambda {
 	return l
 }
 
-// ProcessEvents polls the Lambda Extension API for events. Currently all this
-// does is signal readiness to the Lambda platform after each event, which is
+// ProcessEvents polls the Lambda Extension API for events. It calls onInvoke for
+// each INVOKE event and then signals readiness to the Lambda platform, which is
 // required in the Extension API.
 // The first call to NextEvent signals completion of the extension
 // init phase.
-func (c *Lambda) ProcessEvents(ctx context.Context) error {
+func (c *Lambda) ProcessEvents(ctx context.Context, onInvoke func(context.Context)) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -81,14 +83,27 @@ func (c *Lambda) ProcessEvents(ctx context.Context) error {
 			if err != nil {
 				return fmt.Errorf("failed to receive next event: %w", err)
 			}
-			// Exit if we receive a SHUTDOWN event
-			if res.EventType == Shutdown {
+			switch res.EventType {
+			case Invoke:
+				c.invoke(ctx, res, onInvoke)
+			case Shutdown:
+				// Exit if we receive a SHUTDOWN event
 				return nil
 			}
 		}
 	}
 }
 
+// invoke calls onInvoke with a context that expires at the invocation's deadline.
+func (c *Lambda) invoke(ctx context.Context, res *NextEventResponse, onInvoke func(context.Context)) {
+	if res.DeadlineMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(res.DeadlineMs))
+		defer cancel()
+	}
+	onInvoke(ctx)
+}
+
 // Register the named extension with the Lambda Extensions API
 // The interface value i is the name of the extension to register as a string.
 // If i is not a string a non-nil error is returned.
@@ -102,7 +117,7 @@ func (c *Lambda) Register(ctx context.Context, i interface{}) error {
 	}
 
 	reqBody, err := json.Marshal(map[string]interface{}{
-		"events": []EventType{Shutdown},
+		"events": []EventType{Invoke, Shutdown},
 	})
 	if err != nil {
 		return err
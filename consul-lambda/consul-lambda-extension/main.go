@@ -103,7 +103,22 @@ func configure() (*Config, error) {
 		return cfg, fmt.Errorf("failed to create AWS SDK configuration: %w", err)
 	}
 
-	ssmClient := client.NewSSM(&sdkConfig, "")
+	// Select the store for the extension data from the scheme of the prefix.
+	store, prefix, err := client.ParseStorePath(cfg.ExtensionDataPrefix)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid extension data prefix: %w", err)
+	}
+	cfg.ExtensionDataPrefix = prefix
+	switch store {
+	case client.StoreSecretsManager:
+		// The extension only reads extension data so the options for writes and deletions do not apply.
+		cfg.Store, err = client.NewSecretsManager(&sdkConfig, client.SecretsManagerOptions{})
+		if err != nil {
+			return cfg, err
+		}
+	default:
+		cfg.Store = client.NewSSM(&sdkConfig, client.SSMOptions{})
+	}
 
 	lambdaClient := NewLambda()
 	err = lambdaClient.Register(context.Background(), extensionName)
@@ -112,7 +127,6 @@ func configure() (*Config, error) {
 	}
 
 	cfg.Events = lambdaClient
-	cfg.Store = ssmClient
 	return cfg, nil
 }
 
@@ -0,0 +1,180 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// livenessTimeout is how long get waits for a read from an idle connection to check that it is still open.
+// A read with a deadline in the past fails without checking the connection so it must be in the future.
+const livenessTimeout = time.Millisecond
+
+// connPool maintains a pool of idle connections to the mesh gateway for an upstream so that
+// connections from the function do not have to wait for the TCP and TLS connection setup.
+// The pool is refilled in the background as connections are taken from it.
+type connPool struct {
+	dial    func() (net.Conn, error)
+	size    int
+	maxIdle time.Duration
+	logger  hclog.Logger
+
+	// mu guards the idle connections and the generation.
+	mu   sync.Mutex
+	idle []idleConn
+	// gen is incremented each time the pool is reset so that connections dialed with a
+	// previous configuration are discarded.
+	gen uint64
+
+	refillCh chan struct{}
+}
+
+// idleConn is a connection in the pool and the time it was established.
+type idleConn struct {
+	net.Conn
+	since time.Time
+}
+
+// newConnPool returns a pool that holds up to size connections created by dial.
+// Connections that have been idle for longer than maxIdle are closed instead of being used.
+func newConnPool(dial func() (net.Conn, error), size int, maxIdle time.Duration, logger hclog.Logger) *connPool {
+	return &connPool{
+		dial:     dial,
+		size:     size,
+		maxIdle:  maxIdle,
+		logger:   logger,
+		refillCh: make(chan struct{}, 1),
+	}
+}
+
+// run refills the pool whenever it is requested until the context is cancelled.
+// All idle connections are closed when it returns.
+func (p *connPool) run(ctx context.Context) {
+	defer p.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.refillCh:
+			p.fill(ctx)
+		}
+	}
+}
+
+// get returns an idle connection from the pool or nil if there are none.
+// Connections that were closed while they were idle are discarded.
+func (p *connPool) get() net.Conn {
+	defer p.refill()
+	for {
+		conn := p.next()
+		if conn == nil {
+			return nil
+		}
+		if alive(conn) {
+			return conn
+		}
+		p.logger.Debug("discarding pooled connection that was closed by the mesh gateway")
+		conn.Close()
+	}
+}
+
+// next removes the oldest idle connection from the pool and returns it or nil if there are none.
+func (p *connPool) next() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpired()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[0]
+	p.idle = p.idle[1:]
+	return conn.Conn
+}
+
+// alive reports whether an idle connection is still open.
+// The mesh gateway never sends data on an idle connection so a read that times out means the
+// connection is open, while EOF means it was closed and any data means it is unusable.
+func alive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(livenessTimeout)); err != nil {
+		return false
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// reset closes all idle connections and refills the pool.
+// It is called when the configuration used to dial the connections changes.
+func (p *connPool) reset() {
+	p.close()
+	p.refill()
+}
+
+// close closes all idle connections and discards any connection that is being dialed.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.gen++
+	for _, conn := range p.idle {
+		conn.Close()
+	}
+	p.idle = nil
+}
+
+// refill requests that the pool is refilled without blocking.
+func (p *connPool) refill() {
+	select {
+	case p.refillCh <- struct{}{}:
+	default:
+		// A refill is already pending.
+	}
+}
+
+// fill dials connections until the pool is full.
+// It stops at the first dial error; the next request for a connection retries the refill.
+func (p *connPool) fill(ctx context.Context) {
+	for ctx.Err() == nil {
+		p.mu.Lock()
+		p.evictExpired()
+		if len(p.idle) >= p.size {
+			p.mu.Unlock()
+			return
+		}
+		gen := p.gen
+		p.mu.Unlock()
+
+		conn, err := p.dial()
+		if err != nil {
+			p.logger.Debug("failed to pre-establish connection to mesh gateway", "error", err)
+			return
+		}
+
+		p.mu.Lock()
+		if gen == p.gen && ctx.Err() == nil {
+			p.idle = append(p.idle, idleConn{Conn: conn, since: time.Now()})
+		} else {
+			conn.Close()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// evictExpired closes the connections that have been idle for too long.
+// It must be called with mu held.
+func (p *connPool) evictExpired() {
+	for len(p.idle) > 0 && time.Since(p.idle[0].since) >= p.maxIdle {
+		p.idle[0].Close()
+		p.idle = p.idle[1:]
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeDialer dials connections that are backed by in-memory pipes.
+type pipeDialer struct {
+	t       *testing.T
+	dialed  []net.Conn
+	servers []net.Conn
+	// onDial is called before each connection is returned if it is set.
+	onDial func()
+}
+
+func (d *pipeDialer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	d.t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	d.dialed = append(d.dialed, client)
+	d.servers = append(d.servers, server)
+	if d.onDial != nil {
+		d.onDial()
+	}
+	return client, nil
+}
+
+// closed reports whether the connection has been closed.
+func closed(conn net.Conn) bool {
+	_, err := conn.Write([]byte("a"))
+	return errors.Is(err, io.ErrClosedPipe)
+}
+
+func TestConnPoolGet(t *testing.T) {
+	d := &pipeDialer{t: t}
+	p := newConnPool(d.dial, 2, time.Minute, hclog.NewNullLogger())
+
+	// An empty pool returns no connection and requests a refill.
+	require.Nil(t, p.get())
+	require.Len(t, p.refillCh, 1)
+
+	// Connections are returned in the order they were established.
+	p.fill(context.Background())
+	require.Len(t, d.dialed, 2)
+	require.Equal(t, d.dialed[0], p.get())
+	require.Equal(t, d.dialed[1], p.get())
+	require.Nil(t, p.get())
+}
+
+func TestConnPoolGetClosed(t *testing.T) {
+	d := &pipeDialer{t: t}
+	p := newConnPool(d.dial, 3, time.Minute, hclog.NewNullLogger())
+
+	// Connections that were closed by the mesh gateway are discarded.
+	p.fill(context.Background())
+	d.servers[0].Close()
+	d.servers[2].Close()
+	require.Equal(t, d.dialed[1], p.get())
+	require.True(t, closed(d.dialed[0]))
+
+	// The pool is empty once all the connections have been discarded.
+	require.Nil(t, p.get())
+	require.True(t, closed(d.dialed[2]))
+}
+
+func TestConnPoolEvictExpired(t *testing.T) {
+	d := &pipeDialer{t: t}
+	p := newConnPool(d.dial, 2, 10*time.Millisecond, hclog.NewNullLogger())
+
+	// Connections that have been idle for too long are closed instead of being returned.
+	p.fill(context.Background())
+	time.Sleep(10 * time.Millisecond)
+	require.Nil(t, p.get())
+	require.True(t, closed(d.dialed[0]))
+	require.True(t, closed(d.dialed[1]))
+}
+
+func TestConnPoolReset(t *testing.T) {
+	d := &pipeDialer{t: t}
+	p := newConnPool(d.dial, 1, time.Minute, hclog.NewNullLogger())
+
+	// Idle connections are closed on reset.
+	p.fill(context.Background())
+	p.reset()
+	require.True(t, closed(d.dialed[0]))
+	require.Nil(t, p.get())
+	require.Len(t, p.refillCh, 1)
+
+	// A connection that was dialed before a reset is discarded.
+	d.onDial = func() {
+		d.onDial = nil
+		p.reset()
+	}
+	p.fill(context.Background())
+	require.Len(t, d.dialed, 3)
+	require.True(t, closed(d.dialed[1]))
+	require.Equal(t, d.dialed[2], p.get())
+}
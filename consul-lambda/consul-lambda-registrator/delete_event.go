@@ -4,7 +4,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 
 	"github.com/hashicorp/consul/api"
@@ -70,10 +69,7 @@ func (env Environment) deleteTLSData(e DeleteEvent) error {
 		service.EnterpriseMeta = e.EnterpriseMeta
 	}
 
-	// TODO: do we need to pass a context in here?.. like from the lambda entrypoint
-	// so that this call can be canceled if necessary.
-	return env.Store.Delete(context.Background(),
-		fmt.Sprintf("%s%s", env.ExtensionDataPrefix, service.ExtensionPath()))
+	return env.Store.Delete(env.context(), env.extensionDataPath(service))
 }
 
 func (e DeleteEvent) writeOptions() *api.WriteOptions {
@@ -5,17 +5,23 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/proto-public/pbconnectca"
 	"github.com/hashicorp/go-hclog"
 	"github.com/kelseyhightower/envconfig"
 
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
 )
 
 // Config holds the configuration from the environment.
@@ -45,16 +51,91 @@ type Config struct {
 	// ConsulHTTPToken is the path to the Consul HTTP token in Parameter Store.
 	ConsulHTTPTokenPath string `envconfig:"CONSUL_HTTP_TOKEN_PATH"`
 
-	// ExtensionDataPrefix is the path in Parameter Store where extension data will be written.
+	// ExtensionDataPrefix is the path where extension data will be written.
+	// The path can be prefixed with a scheme that selects the store: ssm:// for Parameter Store or
+	// sm:// for Secrets Manager. Paths without a scheme are in Parameter Store.
+	// The scheme is removed from the prefix when the configuration is loaded.
 	ExtensionDataPrefix string `envconfig:"CONSUL_EXTENSION_DATA_PREFIX"`
 
+	// ExtensionDataStore is the store selected by the scheme of the ExtensionDataPrefix.
+	ExtensionDataStore string `ignored:"true"`
+
+	// ExtensionDataRecoveryWindow is the number of days that deleted extension data can be recovered
+	// when it is stored in Secrets Manager. It must be 0 or between 7 and 30.
+	// If this value is zero the extension data is deleted immediately, as it is in Parameter Store.
+	ExtensionDataRecoveryWindow int `envconfig:"CONSUL_EXTENSION_DATA_RECOVERY_WINDOW" default:"0"`
+
+	// ConsulGRPCAddr is the address of the Consul server's gRPC interface, including the scheme, e.g.
+	// https://consul.example.com:8503. When set, Lambda registrator generates the private key for each
+	// service's leaf certificate locally and has its CSR signed by the Connect CA gRPC service. This is
+	// required to issue certificates with the correct SPIFFE ID for services in non-default partitions.
+	ConsulGRPCAddr string `envconfig:"CONSUL_GRPC_ADDR"`
+
 	// ExtensionDataTier is the tier to use for storing data in Parameter Store.
 	// Refer to the Parameter Store documentation for applicable values.
 	// If this value is not set the default value from the AWS SDK will be used.
 	ExtensionDataTier string `envconfig:"CONSUL_EXTENSION_DATA_TIER"`
 
+	// ExtensionDataKMSKeyID is the ID, ARN or alias of the KMS key used to encrypt the extension data.
+	// If this value is not set the account's default key for the store is used.
+	ExtensionDataKMSKeyID string `envconfig:"CONSUL_EXTENSION_DATA_KMS_KEY_ID"`
+
+	// RawExtensionDataTags is the JSON object of the AWS tags applied to the extension data of every service,
+	// for example {"team":"payments"}. Tag values may contain any character, including commas and colons.
+	RawExtensionDataTags string `envconfig:"CONSUL_EXTENSION_DATA_TAGS"`
+
+	// ExtensionDataTags are the AWS tags applied to the extension data of every service.
+	// The ARN, partition and namespace of the Lambda function are also applied to the extension data of each service.
+	ExtensionDataTags map[string]string `ignored:"true"`
+
+	// ExtensionDataExpiration enables an expiration policy that deletes the extension data in Parameter
+	// Store when the leaf certificate that it contains expires.
+	// Parameter policies are only supported by the advanced tier so the extension data is written to the advanced tier.
+	// If the certificate is not rotated before it expires, for example because Lambda registrator is failing,
+	// the deleted extension data is treated by the extension as the function being removed from the service mesh:
+	// all of the function's outbound connections are closed until the extension data is written again.
+	ExtensionDataExpiration bool `envconfig:"CONSUL_EXTENSION_DATA_EXPIRATION" default:"false"`
+
+	// LeafCertRotationWindow is the time before a leaf certificate expires that it is rotated.
+	// During each full sync the leaf certificate in the extension data of every managed service is
+	// rotated if it expires within this window or if the Consul CA root has been rotated.
+	LeafCertRotationWindow time.Duration `envconfig:"LEAF_CERT_ROTATION_WINDOW" default:"24h"`
+
+	// MeshGatewayServiceName is the name of the mesh gateway service in Consul.
+	// The addresses of the healthy instances of the service in each function's datacenter and partition
+	// are written to the extension data so that the function discovers the mesh gateways.
+	// Discovery is disabled by default because the discovered addresses take precedence over the mesh
+	// gateway addresses configured for the extension. If this value is empty mesh gateway discovery is disabled.
+	MeshGatewayServiceName string `envconfig:"MESH_GATEWAY_SERVICE_NAME" default:""`
+
 	// PageSize is the maximum number of Lambda functions per page when querying the Lambda API.
 	PageSize int `envconfig:"PAGE_SIZE" default:"50"`
+
+	// LambdaListStrategy determines how Lambda functions are listed during a full sync.
+	// When set to "lambda" the functions are listed with the Lambda API and GetFunction is called for each function.
+	// When set to "tagging" the functions and their tags are listed in bulk with the Resource Groups
+	// Tagging API, falling back to the Lambda API on failure. This requires the tag:GetResources permission.
+	LambdaListStrategy string `envconfig:"LAMBDA_LIST_STRATEGY" default:"lambda"`
+
+	// Concurrency is the maximum number of Lambda functions that are retrieved, or events that are
+	// reconciled, at the same time.
+	Concurrency int `envconfig:"CONCURRENCY" default:"10"`
+
+	// LambdaAPIRateLimit is the maximum number of requests per second made to the Lambda API.
+	// If this value is zero the requests are not rate limited.
+	LambdaAPIRateLimit float64 `envconfig:"LAMBDA_API_RATE_LIMIT" default:"10"`
+
+	// ConsulAPIRateLimit is the maximum number of requests per second made to the Consul API.
+	// If this value is zero the requests are not rate limited.
+	ConsulAPIRateLimit float64 `envconfig:"CONSUL_API_RATE_LIMIT" default:"50"`
+
+	// DryRun disables all writes to Consul and the parameter store. Instead of reconciling events,
+	// Lambda registrator returns a plan of the changes that it would make.
+	DryRun bool `envconfig:"DRY_RUN" default:"false"`
+
+	// FailOnReconcileError marks the invocation as failed when any event fails to reconcile.
+	// By default the report of the results is returned and the failed events are only counted in it.
+	FailOnReconcileError bool `envconfig:"FAIL_ON_RECONCILE_ERROR" default:"false"`
 }
 
 // initPartitions converts the raw slice of partitions into a map.
@@ -65,11 +146,33 @@ func (c *Config) initPartitions() {
 	}
 }
 
+// initExtensionDataTags decodes the JSON object of extension data tags.
+func (c *Config) initExtensionDataTags() error {
+	if c.RawExtensionDataTags == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(c.RawExtensionDataTags), &c.ExtensionDataTags); err != nil {
+		return fmt.Errorf("invalid extension data tags %q: must be a JSON object of strings: %w", c.RawExtensionDataTags, err)
+	}
+	return nil
+}
+
+// initExtensionDataStore splits the raw extension data prefix into the store and the prefix within the store.
+func (c *Config) initExtensionDataStore() error {
+	store, prefix, err := client.ParseStorePath(c.ExtensionDataPrefix)
+	if err != nil {
+		return err
+	}
+	c.ExtensionDataStore = store
+	c.ExtensionDataPrefix = prefix
+	return nil
+}
+
 // ParamStore is an interface for reading and writing key/value pairs to a data store.
 type ParamStore interface {
 	Delete(ctx context.Context, k string) error
 	Get(ctx context.Context, k string) (string, error)
-	Set(ctx context.Context, k, v string) error
+	Set(ctx context.Context, k, v string, opts ...client.SetOption) error
 }
 
 // LambdaAPIClient is an interface for retrieving information about Lambda functions.
@@ -91,8 +194,32 @@ type Environment struct {
 	// Logger is used to log messages.
 	Logger hclog.Logger
 
-	// Store is data store client used to read and write configuration data.
+	// Store is data store client used to read and write extension data.
 	Store ParamStore
+
+	// ConnectCA is the client for Consul's Connect CA gRPC service that signs leaf certificates.
+	// If it is nil, leaf certificates are retrieved from the Consul agent instead.
+	ConnectCA pbconnectca.ConnectCAServiceClient
+
+	// connectCAConn is the connection used by ConnectCA. It is closed by Close.
+	connectCAConn io.Closer
+
+	// ctx is the context of the invocation. It bounds the requests that are made while reconciling events.
+	ctx context.Context
+
+	// cache holds Consul data that is only retrieved once per invocation.
+	cache *consulCache
+}
+
+// consulCache holds the Consul CA roots, the active root, the datacenter of the Consul agent
+// and the cluster peers of each partition.
+type consulCache struct {
+	mu           sync.Mutex
+	roots        *api.CARootList
+	active       *api.CARoot
+	datacenter   string
+	peers        map[string][]structs.Peer
+	meshGateways map[string][]structs.MeshGateway
 }
 
 const (
@@ -102,13 +229,21 @@ const (
 // SetupEnvironment constructs the processing Environment based on environment variables
 // and Parameter Store.
 func SetupEnvironment(ctx context.Context) (Environment, error) {
-	var env Environment
+	env := Environment{ctx: ctx, cache: &consulCache{}}
 
 	err := envconfig.Process("", &env)
 	if err != nil {
 		return env, err
 	}
 	env.initPartitions()
+	err = env.initExtensionDataStore()
+	if err != nil {
+		return env, err
+	}
+	err = env.initExtensionDataTags()
+	if err != nil {
+		return env, err
+	}
 
 	env.Logger = hclog.New(
 		&hclog.LoggerOptions{
@@ -124,27 +259,84 @@ func SetupEnvironment(ctx context.Context) (Environment, error) {
 		return env, err
 	}
 
-	env.Store = client.NewSSM(&sdkConfig, env.ExtensionDataTier)
-	env.Lambda = NewLambdaClient(&sdkConfig, env.PageSize)
+	// The Consul HTTP token and CA cert are always read from Parameter Store.
+	ssmClient := client.NewSSM(&sdkConfig, client.SSMOptions{})
+	switch env.ExtensionDataStore {
+	case client.StoreSecretsManager:
+		env.Store, err = client.NewSecretsManager(&sdkConfig, client.SecretsManagerOptions{
+			RecoveryWindow: env.ExtensionDataRecoveryWindow,
+			KMSKeyID:       env.ExtensionDataKMSKeyID,
+			Tags:           env.ExtensionDataTags,
+		})
+		if err != nil {
+			return env, err
+		}
+	default:
+		env.Store = client.NewSSM(&sdkConfig, client.SSMOptions{
+			Tier:     env.ExtensionDataTier,
+			KMSKeyID: env.ExtensionDataKMSKeyID,
+			Tags:     env.ExtensionDataTags,
+		})
+	}
+	lambdaClient := NewLambdaClient(&sdkConfig, env.PageSize, env.Concurrency, env.LambdaAPIRateLimit)
+	switch env.LambdaListStrategy {
+	case lambdaListStrategyTagging:
+		env.Lambda = NewTaggingLambdaClient(&sdkConfig, lambdaClient, env.PageSize, env.LambdaAPIRateLimit, env.Logger)
+	case lambdaListStrategyLambda:
+		env.Lambda = lambdaClient
+	default:
+		return env, fmt.Errorf("invalid Lambda list strategy %q", env.LambdaListStrategy)
+	}
 
-	err = setConsulHTTPToken(ctx, env.Store, env.ConsulHTTPTokenPath)
+	err = setConsulHTTPToken(ctx, ssmClient, env.ConsulHTTPTokenPath)
 	if err != nil {
 		return env, err
 	}
 
-	err = setConsulCACert(ctx, env.Store, env.ConsulCACertPath)
+	err = setConsulCACert(ctx, ssmClient, env.ConsulCACertPath)
 	if err != nil {
 		return env, err
 	}
 
-	env.ConsulClient, err = api.NewClient(api.DefaultConfig())
+	consulConfig := api.DefaultConfig()
+	env.ConsulClient, err = api.NewClient(consulConfig)
 	if err != nil {
 		return env, err
 	}
 
+	// The Consul client shares the HTTP client created by api.NewClient so wrapping its
+	// transport limits the rate of every request made by the Consul client.
+	consulConfig.HttpClient.Transport = &rateLimitedTransport{
+		limiter: newLimiter(env.ConsulAPIRateLimit),
+		next:    consulConfig.HttpClient.Transport,
+	}
+
+	if env.ConsulGRPCAddr != "" {
+		env.ConnectCA, env.connectCAConn, err = NewConnectCAClient(env.ConsulGRPCAddr, consulConfig.Token, consulConfig.TLSConfig.CAFile)
+		if err != nil {
+			return env, err
+		}
+	}
+
 	return env, nil
 }
 
+// Close releases the connections that were opened by SetupEnvironment.
+func (env Environment) Close() error {
+	if env.connectCAConn == nil {
+		return nil
+	}
+	return env.connectCAConn.Close()
+}
+
+// context returns the context of the invocation or a background context if there is none.
+func (env Environment) context() context.Context {
+	if env.ctx == nil {
+		return context.Background()
+	}
+	return env.ctx
+}
+
 // IsManagingTLS indicates whether the Environment is configured to retrieve mTLS data from Consul and
 // write it to the parameter store.
 func (env Environment) IsManagingTLS() bool {
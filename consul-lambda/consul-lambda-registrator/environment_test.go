@@ -17,6 +17,8 @@ import (
 
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
 )
 
 const (
@@ -27,6 +29,7 @@ const (
 	partitionsEnvironment    string = "PARTITIONS"
 	logLevelEnvironment      string = "LOG_LEVEL"
 	extensionPathEnvironment string = "CONSUL_EXTENSION_DATA_PREFIX"
+	extensionTagsEnvironment string = "CONSUL_EXTENSION_DATA_TAGS"
 )
 
 func TestSetupEnvironment(t *testing.T) {
@@ -38,6 +41,7 @@ func TestSetupEnvironment(t *testing.T) {
 		partitionsEnvironment:    "a,b",
 		logLevelEnvironment:      "warn",
 		extensionPathEnvironment: "/path/to/data",
+		extensionTagsEnvironment: `{"team":"payments","owners":"a:b,c:d"}`,
 	}
 	for k, v := range envVars {
 		os.Setenv(k, v)
@@ -58,13 +62,31 @@ func TestSetupEnvironment(t *testing.T) {
 	require.Equal(t, envVars[datacenterEnvironment], env.Datacenter)
 	require.Equal(t, envVars[logLevelEnvironment], env.LogLevel)
 	require.Equal(t, envVars[extensionPathEnvironment], env.ExtensionDataPrefix)
+	require.Equal(t, client.StoreSSM, env.ExtensionDataStore)
+	require.Equal(t, map[string]string{"team": "payments", "owners": "a:b,c:d"}, env.ExtensionDataTags)
 	require.NotNil(t, env.Lambda)
 	require.NotNil(t, env.ConsulClient)
 	require.NotNil(t, env.Logger)
 	require.False(t, env.IsEnterprise)
+	require.False(t, env.DryRun)
+	require.False(t, env.FailOnReconcileError)
+	require.Empty(t, env.MeshGatewayServiceName)
+	require.Equal(t, 10, env.Concurrency)
 	require.Equal(t, map[string]struct{}{"a": {}, "b": {}}, env.Partitions)
 }
 
+func TestInitExtensionDataTags(t *testing.T) {
+	c := Config{}
+	require.NoError(t, c.initExtensionDataTags())
+	require.Nil(t, c.ExtensionDataTags)
+
+	c = Config{RawExtensionDataTags: "team:payments"}
+	require.Error(t, c.initExtensionDataTags())
+
+	c = Config{RawExtensionDataTags: `{"team":1}`}
+	require.Error(t, c.initExtensionDataTags())
+}
+
 func TestSetConsulCACert(t *testing.T) {
 	ctx := context.Background()
 	unsetEverything := func() {
@@ -141,6 +163,8 @@ func mockSSMClient(mappings map[string]string) ParamStore {
 
 type mockSSM struct {
 	mappings map[string]string
+	// options records the options of each Set if it is not nil.
+	options map[string]client.SetOptions
 }
 
 var _ ParamStore = (*mockSSM)(nil)
@@ -154,11 +178,14 @@ func (s mockSSM) Get(_ context.Context, key string) (string, error) {
 	if value, ok := s.mappings[key]; ok && value != "" {
 		return value, nil
 	}
-	return "", fmt.Errorf("unable to get %s: not found", key)
+	return "", fmt.Errorf("unable to get %s: %w", key, client.ErrNotFound)
 }
 
-func (s mockSSM) Set(_ context.Context, key, val string) error {
+func (s mockSSM) Set(_ context.Context, key, val string, opts ...client.SetOption) error {
 	s.mappings[key] = val
+	if s.options != nil {
+		s.options[key] = client.NewSetOptions(opts...)
+	}
 	return nil
 }
 
@@ -230,5 +257,10 @@ func (lc mockLambda) GetFunction(_ context.Context, arn string) (LambdaFunction,
 	if fn, ok := lc.Functions[arn]; ok {
 		return fn, nil
 	}
-	return LambdaFunction{}, fmt.Errorf("function %s does not exist", arn)
+	for _, fn := range lc.Functions {
+		if fn.Name == arn {
+			return fn, nil
+		}
+	}
+	return LambdaFunction{}, fmt.Errorf("%w: function %s does not exist", errLambdaNotFound, arn)
 }
@@ -5,12 +5,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	"github.com/hashicorp/go-multierror"
+	"golang.org/x/time/rate"
 )
 
+// errLambdaNotFound is returned by LambdaAPIClient implementations when the requested function does not exist.
+var errLambdaNotFound = errors.New("lambda function not found")
+
 type LambdaFunction struct {
 	ARN  string
 	Name string
@@ -21,21 +28,37 @@ type LambdaFunction struct {
 type Lambda struct {
 	lambdaClient *lambda.Client
 	pageSize     int
+	concurrency  int
+	limiter      *rate.Limiter
 }
 
-// NewLambdaClient returns a Lambda client
-func NewLambdaClient(cfg *aws.Config, pageSize int) *Lambda {
+// NewLambdaClient returns a Lambda client.
+// Up to concurrency functions are retrieved at once when listing functions and requests to the
+// Lambda API are limited to rateLimit requests per second.
+func NewLambdaClient(cfg *aws.Config, pageSize, concurrency int, rateLimit float64) *Lambda {
 	return &Lambda{
 		lambdaClient: lambda.NewFromConfig(*cfg),
 		pageSize:     pageSize,
+		concurrency:  concurrency,
+		limiter:      newLimiter(rateLimit),
 	}
 }
 
+// GetFunction returns the LambdaFunction for the given function name or ARN.
+// If the function does not exist the returned error wraps errLambdaNotFound.
 func (c *Lambda) GetFunction(ctx context.Context, arn string) (LambdaFunction, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return LambdaFunction{}, err
+	}
+
 	fn, err := c.lambdaClient.GetFunction(ctx, &lambda.GetFunctionInput{
 		FunctionName: &arn,
 	})
 	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return LambdaFunction{}, fmt.Errorf("%w: %w", errLambdaNotFound, err)
+		}
 		return LambdaFunction{}, err
 	}
 
@@ -47,6 +70,8 @@ func (c *Lambda) GetFunction(ctx context.Context, arn string) (LambdaFunction, e
 }
 
 // ListFunctions returns a map of LambdaFunction indexed by ARN.
+// It returns an error if any function other than one that was deleted while listing cannot be
+// retrieved, because a function missing from an incomplete listing would be deregistered from Consul.
 func (c *Lambda) ListFunctions(ctx context.Context) (map[string]LambdaFunction, error) {
 	var resultErr error
 	params := &lambda.ListFunctionsInput{MaxItems: aws.Int32(int32(c.pageSize))}
@@ -54,17 +79,29 @@ func (c *Lambda) ListFunctions(ctx context.Context) (map[string]LambdaFunction,
 	lambdas := make(map[string]LambdaFunction)
 
 	for paginator.HasMorePages() {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
 		output, err := paginator.NextPage(ctx)
 		if err != nil {
 			resultErr = multierror.Append(resultErr, err)
 			return nil, resultErr
 		}
 
-		// TODO: fetch Lambda functions concurrently
-		for _, l := range output.Functions {
-			fn, err := c.GetFunction(ctx, *l.FunctionArn)
-			if err != nil {
-				resultErr = multierror.Append(resultErr, err)
+		// Fetch the functions in this page concurrently.
+		fns := make([]LambdaFunction, len(output.Functions))
+		errs := make([]error, len(output.Functions))
+		forEach(len(output.Functions), c.concurrency, func(i int) {
+			fns[i], errs[i] = c.GetFunction(ctx, *output.Functions[i].FunctionArn)
+		})
+
+		for i, fn := range fns {
+			if errors.Is(errs[i], errLambdaNotFound) {
+				continue
+			}
+			if errs[i] != nil {
+				resultErr = multierror.Append(resultErr, errs[i])
 				continue
 			}
 
@@ -72,5 +109,8 @@ func (c *Lambda) ListFunctions(ctx context.Context) (map[string]LambdaFunction,
 		}
 	}
 
+	if resultErr != nil {
+		return nil, resultErr
+	}
 	return lambdas, nil
 }
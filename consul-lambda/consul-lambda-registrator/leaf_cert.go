@@ -0,0 +1,197 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/hashicorp/consul/proto-public/pbconnectca"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+const (
+	consulTokenMetadataKey = "x-consul-token"
+
+	// signTimeout is the maximum time to wait for Consul to sign a CSR.
+	signTimeout = 10 * time.Second
+)
+
+// NewConnectCAClient returns a client for the Connect CA gRPC service at the given address.
+// The address must include the scheme: https connects with TLS, verifying the server against the
+// CA cert in caFile if it is set, and http connects in plaintext.
+// The token is sent with every request to authorize the signing of leaf certificates.
+// The returned io.Closer closes the underlying gRPC connection.
+func NewConnectCAClient(addr, token, caFile string) (pbconnectca.ConnectCAServiceClient, io.Closer, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Consul gRPC address %q: %w", addr, err)
+	}
+
+	var creds credentials.TransportCredentials
+	switch u.Scheme {
+	case "https":
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if caFile != "" {
+			caCert, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return nil, nil, fmt.Errorf("failed to parse Consul CA cert %s", caFile)
+			}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	case "http":
+		creds = insecure.NewCredentials()
+	default:
+		return nil, nil, fmt.Errorf("invalid Consul gRPC address %q: scheme must be http or https", addr)
+	}
+
+	conn, err := grpc.NewClient(u.Host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if token != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, consulTokenMetadataKey, token)
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pbconnectca.NewConnectCAServiceClient(conn), conn, nil
+}
+
+// leafCert returns the PEM encoded leaf certificate and private key for the service.
+//
+// When the Connect CA client is configured the private key is generated locally and a CSR for
+// the service's SPIFFE ID is signed by Consul, so the certificate identifies the service in its
+// own partition and namespace.
+// Otherwise the certificate is retrieved from the Consul agent. Agent API calls cannot target
+// another partition, so the certificate is always scoped to the agent's own partition.
+func (env Environment) leafCert(s structs.Service, trustDomain string) (string, string, error) {
+	if env.ConnectCA == nil {
+		if s.PartitionOrDefault() != "default" {
+			env.Logger.Warn("Leaf cert for a service in a non-default partition is scoped to the Consul agent's partition; set CONSUL_GRPC_ADDR to issue it for the service's partition",
+				"service", s.Name, "partition", s.PartitionOrDefault())
+		}
+		leafCert, _, err := env.ConsulClient.Agent().ConnectCALeaf(s.Name, nil)
+		if err != nil {
+			return "", "", err
+		}
+		return leafCert.CertPEM, leafCert.PrivateKeyPEM, nil
+	}
+
+	// Certificates are signed by the CA of the local datacenter so the SPIFFE ID must be for
+	// that datacenter, even for services in remote datacenters.
+	datacenter, err := env.agentDatacenter()
+	if err != nil {
+		return "", "", err
+	}
+	id := structs.Service{
+		EnterpriseMeta: s.EnterpriseMeta,
+		Name:           s.Name,
+		Datacenter:     datacenter,
+		TrustDomain:    trustDomain,
+	}.SpiffeID()
+
+	csrPEM, keyPEM, err := generateCSR(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(env.context(), signTimeout)
+	defer cancel()
+	resp, err := env.ConnectCA.Sign(ctx, &pbconnectca.SignRequest{Csr: csrPEM})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign CSR for %s: %w", id, err)
+	}
+	return resp.CertPem, keyPEM, nil
+}
+
+// agentDatacenter returns the datacenter of the Consul agent.
+// The datacenter is only retrieved from Consul once for each Environment.
+func (env Environment) agentDatacenter() (string, error) {
+	if env.cache != nil {
+		env.cache.mu.Lock()
+		defer env.cache.mu.Unlock()
+		if env.cache.datacenter != "" {
+			return env.cache.datacenter, nil
+		}
+	}
+
+	self, err := env.ConsulClient.Agent().Self()
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve Consul agent configuration: %w", err)
+	}
+	datacenter, _ := self["Config"]["Datacenter"].(string)
+	if datacenter == "" {
+		return "", fmt.Errorf("failed to determine the Consul agent's datacenter")
+	}
+
+	if env.cache != nil {
+		env.cache.datacenter = datacenter
+	}
+	return datacenter, nil
+}
+
+// parseLeafCert parses the first certificate in the PEM encoded leaf certificate chain.
+func parseLeafCert(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode leaf certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse leaf certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// generateCSR generates an ECDSA private key and a CSR for the given SPIFFE ID.
+// It returns the PEM encoded CSR and private key.
+func generateCSR(spiffeID string) (string, string, error) {
+	uri, err := url.Parse(spiffeID)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid SPIFFE ID %q: %w", spiffeID, err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate private key: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		URIs:               []*url.URL{uri},
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+	}, key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(csrPEM), string(keyPEM), nil
+}
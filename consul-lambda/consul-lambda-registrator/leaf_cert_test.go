@@ -0,0 +1,168 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/proto-public/pbconnectca"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestGenerateCSR(t *testing.T) {
+	id := "spiffe://11111111-2222-3333-4444-555555555555.consul/ap/part1/ns/ns1/dc/dc1/svc/lambda-1234"
+	csrPEM, keyPEM, err := generateCSR(id)
+	require.NoError(t, err)
+
+	block, _ := pem.Decode([]byte(csrPEM))
+	require.NotNil(t, block)
+	require.Equal(t, "CERTIFICATE REQUEST", block.Type)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, csr.CheckSignature())
+	require.Len(t, csr.URIs, 1)
+	require.Equal(t, id, csr.URIs[0].String())
+
+	block, _ = pem.Decode([]byte(keyPEM))
+	require.NotNil(t, block)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(csr.PublicKey))
+
+	_, _, err = generateCSR("%invalid")
+	require.Error(t, err)
+}
+
+func TestNewConnectCAClient(t *testing.T) {
+	_, conn, err := NewConnectCAClient("http://127.0.0.1:8502", "", "")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	_, conn, err = NewConnectCAClient("https://127.0.0.1:8503", "token", "")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	_, _, err = NewConnectCAClient("127.0.0.1:8502", "", "")
+	require.Error(t, err)
+	_, _, err = NewConnectCAClient("https://127.0.0.1:8503", "", "/does/not/exist")
+	require.Error(t, err)
+}
+
+func TestUpsertTLSData_ConnectCA(t *testing.T) {
+	server, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+	server.WaitForActiveCARoot(t)
+
+	consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+	require.NoError(t, err)
+	roots, _, err := consulClient.Agent().ConnectCARoots(nil)
+	require.NoError(t, err)
+
+	connectCA, conn, err := NewConnectCAClient("http://"+server.GRPCAddr, "", "")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	cases := map[string]struct {
+		service    structs.Service
+		connectCA  pbconnectca.ConnectCAServiceClient
+		expectedID string
+	}{
+		"Default partition": {
+			service:    structs.Service{Name: "lambda-1234"},
+			connectCA:  connectCA,
+			expectedID: "spiffe://" + roots.TrustDomain + "/ns/default/dc/dc1/svc/lambda-1234",
+		},
+		"Remote datacenter": {
+			service:    structs.Service{Name: "lambda-1234", Datacenter: "dc2"},
+			connectCA:  connectCA,
+			expectedID: "spiffe://" + roots.TrustDomain + "/ns/default/dc/dc1/svc/lambda-1234",
+		},
+		"Non-default partition": {
+			service:    structs.Service{Name: "lambda-1234", EnterpriseMeta: structs.NewEnterpriseMeta("part1", "ns1")},
+			connectCA:  &mockConnectCA{t: t},
+			expectedID: "spiffe://" + roots.TrustDomain + "/ap/part1/ns/ns1/dc/dc1/svc/lambda-1234",
+		},
+		"Agent leaf cert": {
+			service:    structs.Service{Name: "lambda-1234"},
+			expectedID: "spiffe://" + roots.TrustDomain + "/ns/default/dc/dc1/svc/lambda-1234",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			data := make(map[string]string)
+			env := mockEnvironment(mockLambdaClient(), consulClient)
+			env.ExtensionDataPrefix = "/prefix"
+			env.Store = mockSSMClient(data)
+			env.ConnectCA = c.connectCA
+			// Partitions are not supported by the Consul test server so the peers are cached.
+			env.cache = &consulCache{peers: map[string][]structs.Peer{"part1": nil}}
+
+			require.NoError(t, env.upsertTLSData(c.service, ""))
+
+			d, err := env.Store.Get(context.Background(), env.extensionDataPath(c.service))
+			require.NoError(t, err)
+			var extData structs.ExtensionData
+			require.NoError(t, json.Unmarshal([]byte(d), &extData))
+
+			// The private key must match the signed leaf certificate.
+			keyPair, err := tls.X509KeyPair([]byte(extData.CertPEM), []byte(extData.PrivateKeyPEM))
+			require.NoError(t, err)
+			cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+			require.NoError(t, err)
+			require.Len(t, cert.URIs, 1)
+			require.Equal(t, c.expectedID, cert.URIs[0].String())
+		})
+	}
+}
+
+// mockConnectCA signs CSRs with a test CA because the Consul test server does not support partitions.
+type mockConnectCA struct {
+	pbconnectca.ConnectCAServiceClient
+	t *testing.T
+}
+
+func (m *mockConnectCA) Sign(ctx context.Context, in *pbconnectca.SignRequest, _ ...grpc.CallOption) (*pbconnectca.SignResponse, error) {
+	_, ok := ctx.Deadline()
+	require.True(m.t, ok, "CSR must be signed with a timeout")
+
+	block, _ := pem.Decode([]byte(in.Csr))
+	require.NotNil(m.t, block)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(m.t, err)
+	require.NoError(m.t, csr.CheckSignature())
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(m.t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		URIs:         csr.URIs,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+	require.NoError(m.t, err)
+
+	return &pbconnectca.SignResponse{
+		CertPem: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})),
+	}, nil
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -24,6 +25,7 @@ func HandleRequest(ctx context.Context, rawEvent map[string]interface{}) (string
 		fmt.Println("Error setting up the environment: %w", err)
 		return "", fmt.Errorf("setting up consul environment:  %w", err)
 	}
+	defer env.Close()
 
 	events, err := GetEvents(ctx, env, rawEvent)
 	if err != nil {
@@ -31,24 +33,40 @@ func HandleRequest(ctx context.Context, rawEvent map[string]interface{}) (string
 		return "", fmt.Errorf("error getting events: %w", err)
 	}
 
-	env.Logger.Info("Processing events", "count", len(events))
+	if env.IsDryRun(rawEvent) {
+		env.Logger.Info("Planning events", "count", len(events))
+		plan, err := json.Marshal(env.PlanEvents(events))
+		if err != nil {
+			return "", fmt.Errorf("error marshaling plan: %w", err)
+		}
+		return string(plan), nil
+	}
 
-	var resultErr error
+	env.Logger.Info("Processing events", "count", len(events))
 
-	for _, event := range events {
-		err := event.Reconcile(env)
+	report, resultErr := env.ReconcileEvents(events)
+	env.Logger.Info("Processed events", "succeeded", report.Succeeded, "failed", report.Failed)
 
-		if err != nil {
-			env.Logger.Warn("Error reconciling event", "error", err, "identifier", event.Identifier())
-			resultErr = multierror.Append(resultErr, err)
-		}
+	// The report is returned even when some events failed so that partial failures can be audited.
+	// The Lambda runtime discards the response when an error is returned, so the failed events are
+	// only reported as an error when the invocation is configured to fail.
+	out, err := json.Marshal(report)
+	if err != nil {
+		return "", multierror.Append(resultErr, fmt.Errorf("error marshaling report: %w", err))
+	}
+	if resultErr != nil && env.FailOnReconcileError {
+		// Log the report so that it is not lost when the response is discarded.
+		env.Logger.Info("Reconcile report", "report", string(out))
+		return string(out), resultErr
 	}
 
-	return "", resultErr
+	return string(out), nil
 }
 
 type Event interface {
 	Reconcile(Environment) error
+	// Plan returns the changes that Reconcile would make without applying them.
+	Plan(Environment) []PlannedChange
 	Identifier() string
 }
 
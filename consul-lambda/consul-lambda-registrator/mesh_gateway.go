@@ -0,0 +1,104 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+const (
+	taggedAddressLAN = "lan"
+	taggedAddressWAN = "wan"
+)
+
+// meshGateways returns the healthy mesh gateways in the service's datacenter and partition.
+// It returns nil if mesh gateway discovery is disabled.
+// The mesh gateways for each datacenter and partition are only retrieved from Consul once for each Environment.
+func (env Environment) meshGateways(s structs.Service) ([]structs.MeshGateway, error) {
+	if env.MeshGatewayServiceName == "" {
+		return nil, nil
+	}
+
+	partition := ""
+	if s.EnterpriseMeta != nil {
+		partition = s.Partition
+	}
+	key := s.Datacenter + "/" + partition
+
+	if env.cache != nil {
+		env.cache.mu.Lock()
+		defer env.cache.mu.Unlock()
+		if gateways, ok := env.cache.meshGateways[key]; ok {
+			return gateways, nil
+		}
+	}
+
+	opts := &api.QueryOptions{Datacenter: s.Datacenter, Partition: partition}
+	entries, _, err := env.ConsulClient.Health().Service(env.MeshGatewayServiceName, "", true, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mesh gateways: %w", err)
+	}
+	gateways := meshGatewaysFromEntries(entries)
+
+	if env.cache != nil {
+		if env.cache.meshGateways == nil {
+			env.cache.meshGateways = make(map[string][]structs.MeshGateway)
+		}
+		env.cache.meshGateways[key] = gateways
+	}
+	return gateways, nil
+}
+
+// meshGatewaysFromEntries returns the addresses of the mesh gateway service instances sorted by LAN address.
+func meshGatewaysFromEntries(entries []*api.ServiceEntry) []structs.MeshGateway {
+	var gateways []structs.MeshGateway
+	for _, entry := range entries {
+		if entry.Service == nil || entry.Service.Kind != api.ServiceKindMeshGateway {
+			continue
+		}
+		gateways = append(gateways, structs.MeshGateway{
+			LANAddress: lanAddress(entry),
+			WANAddress: taggedAddress(entry.Service, taggedAddressWAN),
+		})
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		if gateways[i].LANAddress != gateways[j].LANAddress {
+			return gateways[i].LANAddress < gateways[j].LANAddress
+		}
+		return gateways[i].WANAddress < gateways[j].WANAddress
+	})
+	return gateways
+}
+
+// lanAddress returns the LAN address of the service instance. It defaults to the service address,
+// or the node address if the service does not have one.
+func lanAddress(entry *api.ServiceEntry) string {
+	if addr := taggedAddress(entry.Service, taggedAddressLAN); addr != "" {
+		return addr
+	}
+	host := entry.Service.Address
+	if host == "" && entry.Node != nil {
+		host = entry.Node.Address
+	}
+	if host == "" {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+}
+
+// taggedAddress returns the host:port form of the service's tagged address with the given name
+// or an empty string if it is not set.
+func taggedAddress(s *api.AgentService, name string) string {
+	addr, ok := s.TaggedAddresses[name]
+	if !ok || addr.Address == "" {
+		return ""
+	}
+	return net.JoinHostPort(addr.Address, strconv.Itoa(addr.Port))
+}
@@ -0,0 +1,101 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestMeshGatewaysFromEntries(t *testing.T) {
+	entries := []*api.ServiceEntry{
+		{
+			Node: &api.Node{Address: "10.0.0.2"},
+			Service: &api.AgentService{
+				Kind: api.ServiceKindMeshGateway,
+				Port: 8443,
+				TaggedAddresses: map[string]api.ServiceAddress{
+					"lan": {Address: "10.0.1.2", Port: 8443},
+					"wan": {Address: "gateway-b.example.com", Port: 443},
+				},
+			},
+		},
+		{
+			Node:    &api.Node{Address: "10.0.0.1"},
+			Service: &api.AgentService{Kind: api.ServiceKindMeshGateway, Port: 8443},
+		},
+		{
+			Node:    &api.Node{Address: "10.0.0.3"},
+			Service: &api.AgentService{Kind: api.ServiceKindTypical, Port: 8080},
+		},
+	}
+
+	require.Equal(t, []structs.MeshGateway{
+		{LANAddress: "10.0.0.1:8443"},
+		{LANAddress: "10.0.1.2:8443", WANAddress: "gateway-b.example.com:443"},
+	}, meshGatewaysFromEntries(entries))
+	require.Empty(t, meshGatewaysFromEntries(nil))
+}
+
+func TestUpsertTLSData_MeshGateways(t *testing.T) {
+	server, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+	server.WaitForActiveCARoot(t)
+
+	consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+	require.NoError(t, err)
+
+	// Register a healthy and an unhealthy mesh gateway.
+	require.NoError(t, consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		Kind:    api.ServiceKindMeshGateway,
+		ID:      "mesh-gateway-1",
+		Name:    "mesh-gateway",
+		Address: "10.0.0.1",
+		Port:    8443,
+		TaggedAddresses: map[string]api.ServiceAddress{
+			"wan": {Address: "198.51.100.1", Port: 443},
+		},
+	}))
+	require.NoError(t, consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		Kind:    api.ServiceKindMeshGateway,
+		ID:      "mesh-gateway-2",
+		Name:    "mesh-gateway",
+		Address: "10.0.0.2",
+		Port:    8443,
+		Check:   &api.AgentServiceCheck{TTL: "10m", Status: api.HealthCritical},
+	}))
+
+	data := make(map[string]string)
+	env := mockEnvironment(mockLambdaClient(), consulClient)
+	env.ExtensionDataPrefix = "/prefix"
+	env.MeshGatewayServiceName = "mesh-gateway"
+	env.Store = mockSSMClient(data)
+	env.cache = &consulCache{}
+
+	service := structs.Service{Name: "lambda-1234"}
+	require.NoError(t, env.upsertTLSData(service, ""))
+
+	var extData structs.ExtensionData
+	require.NoError(t, json.Unmarshal([]byte(data[env.extensionDataPath(service)]), &extData))
+	require.Equal(t, []structs.MeshGateway{
+		{LANAddress: "10.0.0.1:8443", WANAddress: "198.51.100.1:443"},
+	}, extData.MeshGateways)
+
+	// Mesh gateway discovery is disabled without a service name.
+	env.MeshGatewayServiceName = ""
+	env.cache = &consulCache{}
+	require.NoError(t, env.upsertTLSData(service, ""))
+	extData = structs.ExtensionData{}
+	require.NoError(t, json.Unmarshal([]byte(data[env.extensionDataPath(service)]), &extData))
+	require.Empty(t, extData.MeshGateways)
+}
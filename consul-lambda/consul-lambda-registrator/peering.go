@@ -0,0 +1,83 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+// peeringServerNameInfix separates the datacenter from the trust domain in a peer's server name,
+// which has the form `server.<datacenter>.peering.<trust domain>`.
+const peeringServerNameInfix = ".peering."
+
+// activePeers returns the established cluster peers of the service's partition.
+// The peers for each partition are only retrieved from Consul once for each Environment.
+func (env Environment) activePeers(s structs.Service) ([]structs.Peer, error) {
+	partition := ""
+	if s.EnterpriseMeta != nil {
+		partition = s.Partition
+	}
+
+	if env.cache != nil {
+		env.cache.mu.Lock()
+		defer env.cache.mu.Unlock()
+		if peers, ok := env.cache.peers[partition]; ok {
+			return peers, nil
+		}
+	}
+
+	peerings, _, err := env.ConsulClient.Peerings().List(env.context(), &api.QueryOptions{Partition: partition})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster peerings: %w", err)
+	}
+	peers := peersFromPeerings(peerings)
+
+	if env.cache != nil {
+		if env.cache.peers == nil {
+			env.cache.peers = make(map[string][]structs.Peer)
+		}
+		env.cache.peers[partition] = peers
+	}
+	return peers, nil
+}
+
+// peersFromPeerings returns the active peerings as a list of peers sorted by name.
+func peersFromPeerings(peerings []*api.Peering) []structs.Peer {
+	var peers []structs.Peer
+	for _, p := range peerings {
+		if p.State != api.PeeringStateActive {
+			continue
+		}
+		peers = append(peers, structs.Peer{
+			Name:         p.Name,
+			TrustDomain:  peerTrustDomain(p),
+			RootCertPEMs: p.PeerCAPems,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+	return peers
+}
+
+// peerTrustDomain returns the trust domain of the peer from the server name in the peering.
+func peerTrustDomain(p *api.Peering) string {
+	_, td, ok := strings.Cut(p.PeerServerName, peeringServerNameInfix)
+	if !ok {
+		return ""
+	}
+	return td
+}
+
+// equalPeers returns true if both lists contain the same peers in the same order.
+// Nil and empty lists are equal.
+func equalPeers(a, b []structs.Peer) bool {
+	return slices.EqualFunc(a, b, func(x, y structs.Peer) bool {
+		return x.Name == y.Name && x.TrustDomain == y.TrustDomain && slices.Equal(x.RootCertPEMs, y.RootCertPEMs)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestPeersFromPeerings(t *testing.T) {
+	peerings := []*api.Peering{
+		{
+			Name:           "peer-b",
+			State:          api.PeeringStateActive,
+			PeerServerName: "server.dc2.peering.22222222-2222-2222-2222-222222222222.consul",
+			PeerCAPems:     []string{"root-b"},
+		},
+		{
+			Name:           "peer-a",
+			State:          api.PeeringStateActive,
+			PeerServerName: "server.dc1.peering.11111111-1111-1111-1111-111111111111.consul",
+			PeerCAPems:     []string{"root-a1", "root-a2"},
+		},
+		{
+			Name:  "pending",
+			State: api.PeeringStatePending,
+		},
+		{
+			Name:           "failing",
+			State:          api.PeeringStateFailing,
+			PeerServerName: "server.dc3.peering.33333333-3333-3333-3333-333333333333.consul",
+		},
+	}
+
+	require.Equal(t, []structs.Peer{
+		{Name: "peer-a", TrustDomain: "11111111-1111-1111-1111-111111111111.consul", RootCertPEMs: []string{"root-a1", "root-a2"}},
+		{Name: "peer-b", TrustDomain: "22222222-2222-2222-2222-222222222222.consul", RootCertPEMs: []string{"root-b"}},
+	}, peersFromPeerings(peerings))
+	require.Empty(t, peersFromPeerings(nil))
+	require.Empty(t, peerTrustDomain(&api.Peering{PeerServerName: "invalid"}))
+}
+
+func TestEqualPeers(t *testing.T) {
+	peers := []structs.Peer{{Name: "peer-a", TrustDomain: "a.consul", RootCertPEMs: []string{"root-a"}}}
+
+	require.True(t, equalPeers(nil, []structs.Peer{}))
+	require.True(t, equalPeers(peers, []structs.Peer{{Name: "peer-a", TrustDomain: "a.consul", RootCertPEMs: []string{"root-a"}}}))
+	require.False(t, equalPeers(peers, nil))
+	require.False(t, equalPeers(peers, []structs.Peer{{Name: "peer-b", TrustDomain: "a.consul", RootCertPEMs: []string{"root-a"}}}))
+	// The peer's CA roots have been rotated.
+	require.False(t, equalPeers(peers, []structs.Peer{{Name: "peer-a", TrustDomain: "a.consul", RootCertPEMs: []string{"root-a2"}}}))
+}
+
+func TestUpsertTLSData_Peers(t *testing.T) {
+	server, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+	server.WaitForActiveCARoot(t)
+
+	consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+	require.NoError(t, err)
+
+	// A peering that has not been established is not included in the extension data.
+	_, _, err = consulClient.Peerings().GenerateToken(context.Background(), api.PeeringGenerateTokenRequest{PeerName: "pending"}, nil)
+	require.NoError(t, err)
+
+	data := make(map[string]string)
+	env := mockEnvironment(mockLambdaClient(), consulClient)
+	env.ExtensionDataPrefix = "/prefix"
+	env.Store = mockSSMClient(data)
+	env.cache = &consulCache{}
+
+	service := structs.Service{Name: "lambda-1234"}
+	require.NoError(t, env.upsertTLSData(service, ""))
+
+	var extData structs.ExtensionData
+	require.NoError(t, json.Unmarshal([]byte(data[env.extensionDataPath(service)]), &extData))
+	require.Empty(t, extData.Peers)
+
+	// The peers are cached for each partition.
+	peers := []structs.Peer{{Name: "peer-a", TrustDomain: "11111111-1111-1111-1111-111111111111.consul"}}
+	env.cache.peers[""] = peers
+	require.NoError(t, env.upsertTLSData(service, ""))
+	require.NoError(t, json.Unmarshal([]byte(data[env.extensionDataPath(service)]), &extData))
+	require.Equal(t, peers, extData.Peers)
+}
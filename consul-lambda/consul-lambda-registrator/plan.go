@@ -0,0 +1,114 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+const (
+	// dryRunField is the field in the raw event that requests a dry run for a single invocation.
+	dryRunField = "dryRun"
+
+	opCatalogRegister   = "catalog-register"
+	opCatalogDeregister = "catalog-deregister"
+	opConfigEntryWrite  = "config-entry-write"
+	opConfigEntryDelete = "config-entry-delete"
+	opParameterWrite    = "parameter-write"
+	opParameterDelete   = "parameter-delete"
+)
+
+// Plan describes the changes that Lambda registrator would make to Consul and the parameter store
+// to reconcile a set of events.
+type Plan struct {
+	// Changes is the list of changes in the order that they would be applied.
+	Changes []PlannedChange `json:"changes"`
+}
+
+// PlannedChange is a single write or delete that would be made while reconciling an event.
+type PlannedChange struct {
+	// Event is the identifier of the event that requires the change.
+	Event string `json:"event"`
+	// Operation is the kind of change, for example catalog-register or config-entry-delete.
+	Operation string `json:"operation"`
+	// Name is the name of the service or the path of the parameter being changed.
+	Name       string `json:"name"`
+	Datacenter string `json:"datacenter,omitempty"`
+	Partition  string `json:"partition,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	// ConfigEntry is the service-defaults config entry that would be written.
+	ConfigEntry *api.ServiceConfigEntry `json:"configEntry,omitempty"`
+}
+
+// PlanEvents returns the Plan that describes the changes required to reconcile the given events
+// without making any changes.
+func (env Environment) PlanEvents(events []Event) Plan {
+	plan := Plan{Changes: []PlannedChange{}}
+	for _, event := range events {
+		plan.Changes = append(plan.Changes, event.Plan(env)...)
+	}
+	return plan
+}
+
+// IsDryRun returns true if the invocation should only plan changes instead of applying them.
+// A dry run is enabled for every invocation by the environment or for a single invocation by
+// setting the dryRun field in the event.
+func (env Environment) IsDryRun(rawEvent map[string]interface{}) bool {
+	if env.DryRun {
+		return true
+	}
+	dryRun, _ := rawEvent[dryRunField].(bool)
+	return dryRun
+}
+
+// Plan returns the changes that Reconcile would make to upsert the Lambda.
+func (e UpsertEvent) Plan(env Environment) []PlannedChange {
+	configEntryWrite := plannedChange(e, opConfigEntryWrite, e.Name, e.Service)
+	configEntryWrite.ConfigEntry = e.toConsulServiceConfigEntry(e.Name)
+	changes := []PlannedChange{
+		configEntryWrite,
+		plannedChange(e, opCatalogRegister, e.Name, e.Service),
+	}
+
+	if env.IsManagingTLS() {
+		changes = append(changes, plannedChange(e, opParameterWrite, env.extensionDataPath(e.Service), e.Service))
+	}
+	return changes
+}
+
+// Plan returns the changes that Reconcile would make to delete the Lambda.
+func (e DeleteEvent) Plan(env Environment) []PlannedChange {
+	changes := []PlannedChange{
+		plannedChange(e, opConfigEntryDelete, e.Name, e.Service),
+		plannedChange(e, opCatalogDeregister, e.Name, e.Service),
+	}
+
+	if env.IsManagingTLS() {
+		changes = append(changes, plannedChange(e, opParameterDelete, env.extensionDataPath(e.Service), e.Service))
+	}
+	return changes
+}
+
+func plannedChange(e Event, op, name string, s structs.Service) PlannedChange {
+	c := PlannedChange{
+		Event:      e.Identifier(),
+		Operation:  op,
+		Name:       name,
+		Datacenter: s.Datacenter,
+	}
+	if s.EnterpriseMeta != nil {
+		c.Partition = s.Partition
+		c.Namespace = s.Namespace
+	}
+	return c
+}
+
+// extensionDataPath returns the path in the parameter store of the extension data for the given service.
+func (env Environment) extensionDataPath(s structs.Service) string {
+	return fmt.Sprintf("%s%s", env.ExtensionDataPrefix, s.ExtensionPath())
+}
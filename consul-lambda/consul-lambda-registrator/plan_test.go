@@ -0,0 +1,108 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestPlanEvents(t *testing.T) {
+	arn := "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234"
+	em := &structs.EnterpriseMeta{Partition: "ap1", Namespace: "ns1"}
+	upsertEvent := UpsertEvent{
+		Service: structs.Service{Name: "lambda-1234", Datacenter: "dc1", EnterpriseMeta: em},
+		LambdaArguments: LambdaArguments{
+			ARN:            arn,
+			InvocationMode: synchronousInvocationMode,
+		},
+	}
+	deleteEvent := DeleteEvent{structs.Service{Name: "lambda-5678"}}
+
+	cases := map[string]struct {
+		extensionDataPrefix string
+		events              []Event
+		expected            []PlannedChange
+	}{
+		"No events": {
+			expected: []PlannedChange{},
+		},
+		"Upsert": {
+			events: []Event{upsertEvent},
+			expected: []PlannedChange{
+				{
+					Event:       arn,
+					Operation:   opConfigEntryWrite,
+					Name:        "lambda-1234",
+					Datacenter:  "dc1",
+					Partition:   "ap1",
+					Namespace:   "ns1",
+					ConfigEntry: upsertEvent.toConsulServiceConfigEntry("lambda-1234"),
+				},
+				{Event: arn, Operation: opCatalogRegister, Name: "lambda-1234", Datacenter: "dc1", Partition: "ap1", Namespace: "ns1"},
+			},
+		},
+		"Delete": {
+			events: []Event{deleteEvent},
+			expected: []PlannedChange{
+				{Event: "lambda-5678", Operation: opConfigEntryDelete, Name: "lambda-5678"},
+				{Event: "lambda-5678", Operation: opCatalogDeregister, Name: "lambda-5678"},
+			},
+		},
+		"Managing TLS": {
+			extensionDataPrefix: "/prefix",
+			events:              []Event{upsertEvent, deleteEvent},
+			expected: []PlannedChange{
+				{
+					Event:       arn,
+					Operation:   opConfigEntryWrite,
+					Name:        "lambda-1234",
+					Datacenter:  "dc1",
+					Partition:   "ap1",
+					Namespace:   "ns1",
+					ConfigEntry: upsertEvent.toConsulServiceConfigEntry("lambda-1234"),
+				},
+				{Event: arn, Operation: opCatalogRegister, Name: "lambda-1234", Datacenter: "dc1", Partition: "ap1", Namespace: "ns1"},
+				{Event: arn, Operation: opParameterWrite, Name: "/prefix/ap1/ns1/lambda-1234", Datacenter: "dc1", Partition: "ap1", Namespace: "ns1"},
+				{Event: "lambda-5678", Operation: opConfigEntryDelete, Name: "lambda-5678"},
+				{Event: "lambda-5678", Operation: opCatalogDeregister, Name: "lambda-5678"},
+				{Event: "lambda-5678", Operation: opParameterDelete, Name: "/prefix/default/default/lambda-5678"},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			env := mockEnvironment(mockLambdaClient(), nil)
+			env.ExtensionDataPrefix = c.extensionDataPrefix
+
+			plan := env.PlanEvents(c.events)
+			require.Equal(t, c.expected, plan.Changes)
+		})
+	}
+}
+
+func TestIsDryRun(t *testing.T) {
+	cases := map[string]struct {
+		config   bool
+		rawEvent map[string]interface{}
+		expected bool
+	}{
+		"Disabled":                 {rawEvent: map[string]interface{}{"source": "aws.events"}},
+		"Enabled by configuration": {config: true, rawEvent: map[string]interface{}{}, expected: true},
+		"Enabled by event":         {rawEvent: map[string]interface{}{dryRunField: true}, expected: true},
+		"Invalid event field":      {rawEvent: map[string]interface{}{dryRunField: "true"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			env := mockEnvironment(mockLambdaClient(), nil)
+			env.DryRun = c.config
+			require.Equal(t, c.expected, env.IsDryRun(c.rawEvent))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+const (
+	eventTypeUpsert = "upsert"
+	eventTypeDelete = "delete"
+	eventTypeRotate = "rotate"
+
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// Report describes the result of reconciling a set of events.
+type Report struct {
+	// Events holds the result for each event in the order that they were reconciled.
+	Events []EventReport `json:"events"`
+	// Succeeded is the number of events that were reconciled successfully.
+	Succeeded int `json:"succeeded"`
+	// Failed is the number of events that failed to reconcile.
+	Failed int `json:"failed"`
+}
+
+// EventReport describes the result of reconciling a single event.
+type EventReport struct {
+	// Identifier is the identifier of the event.
+	Identifier string `json:"identifier"`
+	// Type is one of upsert, delete or rotate.
+	Type string `json:"type"`
+	// Service is the name of the Consul service.
+	Service    string `json:"service"`
+	Datacenter string `json:"datacenter,omitempty"`
+	Partition  string `json:"partition,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	// Outcome is either success or failure.
+	Outcome string `json:"outcome"`
+	// DurationMs is the time taken to reconcile the event in milliseconds.
+	DurationMs int64 `json:"durationMs"`
+	// Error is the error message if the event failed to reconcile.
+	Error string `json:"error,omitempty"`
+}
+
+// ReconcileEvents reconciles each of the given events and returns a Report of the results.
+// Up to env.Concurrency events are reconciled at the same time.
+// The returned error combines the errors of all events that failed to reconcile.
+func (env Environment) ReconcileEvents(events []Event) (Report, error) {
+	var resultErr error
+	report := Report{Events: make([]EventReport, len(events))}
+	errs := make([]error, len(events))
+
+	forEach(len(events), env.Concurrency, func(i int) {
+		start := time.Now()
+		errs[i] = events[i].Reconcile(env)
+		report.Events[i] = newEventReport(events[i])
+		report.Events[i].DurationMs = time.Since(start).Milliseconds()
+	})
+
+	for i, err := range errs {
+		r := &report.Events[i]
+		if err != nil {
+			env.Logger.Warn("Error reconciling event", "error", err, "identifier", r.Identifier)
+			resultErr = multierror.Append(resultErr, err)
+			r.Outcome = outcomeFailure
+			r.Error = err.Error()
+			report.Failed++
+		} else {
+			r.Outcome = outcomeSuccess
+			report.Succeeded++
+		}
+	}
+
+	return report, resultErr
+}
+
+// newEventReport returns an EventReport that describes the given event.
+func newEventReport(event Event) EventReport {
+	r := EventReport{Identifier: event.Identifier()}
+
+	var service structs.Service
+	switch e := event.(type) {
+	case UpsertEvent:
+		r.Type = eventTypeUpsert
+		service = e.Service
+	case DeleteEvent:
+		r.Type = eventTypeDelete
+		service = e.Service
+	case RotateCertEvent:
+		r.Type = eventTypeRotate
+		service = e.Service
+	}
+
+	r.Service = service.Name
+	r.Datacenter = service.Datacenter
+	if service.EnterpriseMeta != nil {
+		r.Partition = service.Partition
+		r.Namespace = service.Namespace
+	}
+	return r
+}
@@ -0,0 +1,82 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestReconcileEvents(t *testing.T) {
+	arn := "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234"
+	upsertEvent := UpsertEvent{
+		Service: structs.Service{Name: "lambda-1234"},
+		LambdaArguments: LambdaArguments{
+			ARN:            arn,
+			InvocationMode: synchronousInvocationMode,
+		},
+	}
+	deleteEvent := DeleteEvent{structs.Service{Name: "lambda-1234"}}
+
+	t.Run("Success", func(t *testing.T) {
+		server, err := testutil.NewTestServerConfigT(t, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = server.Stop()
+		})
+
+		consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+		require.NoError(t, err)
+
+		env := mockEnvironment(mockLambdaClient(), consulClient)
+		report, err := env.ReconcileEvents([]Event{upsertEvent, deleteEvent})
+		require.NoError(t, err)
+		require.Equal(t, 2, report.Succeeded)
+		require.Equal(t, 0, report.Failed)
+		require.Len(t, report.Events, 2)
+
+		require.Equal(t, arn, report.Events[0].Identifier)
+		require.Equal(t, eventTypeUpsert, report.Events[0].Type)
+		require.Equal(t, "lambda-1234", report.Events[0].Service)
+		require.Equal(t, outcomeSuccess, report.Events[0].Outcome)
+		require.Empty(t, report.Events[0].Error)
+
+		require.Equal(t, "lambda-1234", report.Events[1].Identifier)
+		require.Equal(t, eventTypeDelete, report.Events[1].Type)
+		require.Equal(t, outcomeSuccess, report.Events[1].Outcome)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		// Nothing is listening on this address so every request to Consul fails.
+		consulClient, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
+		require.NoError(t, err)
+
+		e := upsertEvent
+		e.EnterpriseMeta = &structs.EnterpriseMeta{Partition: "ap1", Namespace: "ns1"}
+		e.Datacenter = "dc2"
+
+		env := mockEnvironment(mockLambdaClient(), consulClient)
+		report, err := env.ReconcileEvents([]Event{e})
+		require.Error(t, err)
+		require.Equal(t, 0, report.Succeeded)
+		require.Equal(t, 1, report.Failed)
+		require.Equal(t, EventReport{
+			Identifier: arn,
+			Type:       eventTypeUpsert,
+			Service:    "lambda-1234",
+			Datacenter: "dc2",
+			Partition:  "ap1",
+			Namespace:  "ns1",
+			Outcome:    outcomeFailure,
+			DurationMs: report.Events[0].DurationMs,
+			Error:      report.Events[0].Error,
+		}, report.Events[0])
+		require.NotEmpty(t, report.Events[0].Error)
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+// RotateCertEvent struct holds data for an event that triggers the rotation of a Lambda function's
+// mTLS leaf certificate in its extension data.
+type RotateCertEvent struct {
+	structs.Service
+	// ARN is the ARN of the Lambda function.
+	ARN string
+	// Reason describes why the certificate needs to be rotated.
+	Reason string
+}
+
+// Identifier returns the name of the service whose certificate is being rotated.
+func (e RotateCertEvent) Identifier() string {
+	return e.Name
+}
+
+// Reconcile writes new extension data for the service with the latest leaf certificate and CA roots.
+func (e RotateCertEvent) Reconcile(env Environment) error {
+	env.Logger.Info("Rotating mTLS leaf certificate", "service", e.Name, "reason", e.Reason)
+	return env.upsertTLSData(e.Service, e.ARN)
+}
+
+// Plan returns the changes that Reconcile would make to rotate the certificate.
+func (e RotateCertEvent) Plan(env Environment) []PlannedChange {
+	return []PlannedChange{plannedChange(e, opParameterWrite, env.extensionDataPath(e.Service), e.Service)}
+}
+
+// constructRotateCertEvents determines which of the Lambda services that are not already being upserted
+// need their mTLS leaf certificate rotated. The extension data for each service is read from the parameter
+// store and the certificate is rotated if it is missing or invalid, if it expires within the rotation window
+// or if it was issued by a CA root that is no longer active. The extension data is also rewritten when the
+// cluster peers, or their CA roots, have changed.
+func (env Environment) constructRotateCertEvents(ctx context.Context, lambdas eventMap, events []Event) ([]Event, error) {
+	if !env.IsManagingTLS() {
+		return nil, nil
+	}
+
+	_, caRoot, err := env.activeCARoot()
+	if err != nil {
+		return nil, err
+	}
+
+	upserted := make(map[structs.EnterpriseMeta]map[string]struct{})
+	for _, event := range events {
+		if e, ok := event.(UpsertEvent); ok {
+			em := enterpriseMetaOrDefault(e.EnterpriseMeta)
+			if upserted[em] == nil {
+				upserted[em] = make(map[string]struct{})
+			}
+			upserted[em][e.Name] = struct{}{}
+		}
+	}
+
+	var services []UpsertEvent
+	for em, lambdaEvents := range lambdas {
+		for serviceName, event := range lambdaEvents {
+			e, ok := event.(UpsertEvent)
+			if !ok {
+				continue
+			}
+			if _, ok := upserted[em][serviceName]; ok {
+				continue
+			}
+			services = append(services, e)
+		}
+	}
+
+	reasons := make([]string, len(services))
+	forEach(len(services), env.Concurrency, func(i int) {
+		reasons[i] = env.certRotationReason(ctx, services[i].Service, caRoot)
+	})
+
+	var rotateEvents []Event
+	for i, reason := range reasons {
+		if reason != "" {
+			rotateEvents = append(rotateEvents, RotateCertEvent{Service: services[i].Service, ARN: services[i].ARN, Reason: reason})
+		}
+	}
+	return rotateEvents, nil
+}
+
+// certRotationReason returns the reason that the leaf certificate in the service's extension data
+// needs to be rotated or an empty string if the certificate is up to date.
+// If the extension data cannot be read because of a transient error the service is skipped until
+// the next full sync so that a store outage does not trigger a rotation of every certificate.
+func (env Environment) certRotationReason(ctx context.Context, s structs.Service, caRoot *api.CARoot) string {
+	d, err := env.Store.Get(ctx, env.extensionDataPath(s))
+	if err != nil {
+		if errors.Is(err, client.ErrNotFound) {
+			return "extension data is missing"
+		}
+		env.Logger.Warn("failed to read extension data, skipping certificate rotation check", "service", s.Name, "error", err)
+		return ""
+	}
+
+	var extData structs.ExtensionData
+	if err := json.Unmarshal([]byte(d), &extData); err != nil {
+		return fmt.Sprintf("failed to unmarshal extension data: %s", err)
+	}
+
+	cert, err := parseLeafCert(extData.CertPEM)
+	if err != nil {
+		return err.Error()
+	}
+
+	// Extension data written before the root ID was recorded is compared by the root certificate.
+	if extData.RootID != "" && extData.RootID != caRoot.ID {
+		return "CA root has been rotated"
+	}
+	if extData.RootID == "" && extData.RootCertPEM != caRoot.RootCertPEM {
+		return "CA root has been rotated"
+	}
+
+	peers, err := env.activePeers(s)
+	if err != nil {
+		env.Logger.Warn("failed to list cluster peers, skipping certificate rotation check", "service", s.Name, "error", err)
+		return ""
+	}
+	if !equalPeers(extData.Peers, peers) {
+		return "cluster peers have changed"
+	}
+
+	gateways, err := env.meshGateways(s)
+	if err != nil {
+		env.Logger.Warn("failed to list mesh gateways, skipping certificate rotation check", "service", s.Name, "error", err)
+		return ""
+	}
+	if !slices.Equal(extData.MeshGateways, gateways) {
+		return "mesh gateways have changed"
+	}
+
+	if time.Until(cert.NotAfter) < env.LeafCertRotationWindow {
+		return fmt.Sprintf("leaf certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+// enterpriseMetaOrDefault returns the value of the given EnterpriseMeta or an empty EnterpriseMeta if it is nil.
+func enterpriseMetaOrDefault(em *structs.EnterpriseMeta) structs.EnterpriseMeta {
+	if em == nil {
+		return structs.EnterpriseMeta{}
+	}
+	return *em
+}
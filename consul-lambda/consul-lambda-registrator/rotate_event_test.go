@@ -0,0 +1,175 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestRotateCertEvents(t *testing.T) {
+	s1 := UpsertEvent{
+		Service: structs.Service{Name: "lambda-1234"},
+		LambdaArguments: LambdaArguments{
+			ARN:            "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234",
+			InvocationMode: synchronousInvocationMode,
+		},
+	}
+	service1 := UpsertEventPlusMeta{UpsertEvent: s1, CreateService: true}
+	path := "/prefix/default/default/lambda-1234"
+
+	cases := map[string]struct {
+		window         time.Duration
+		modifyData     func(t *testing.T, data map[string]string)
+		storeErr       error
+		expectedReason string
+	}{
+		"Certificate is valid": {
+			window: time.Minute,
+		},
+		"Certificate expires within the window": {
+			window:         10000 * time.Hour,
+			expectedReason: "leaf certificate expires at",
+		},
+		"CA root has been rotated": {
+			window: time.Minute,
+			modifyData: func(t *testing.T, data map[string]string) {
+				var extData structs.ExtensionData
+				require.NoError(t, json.Unmarshal([]byte(data[path]), &extData))
+				extData.RootID = "old-root-id"
+				d, err := json.Marshal(extData)
+				require.NoError(t, err)
+				data[path] = string(d)
+			},
+			expectedReason: "CA root has been rotated",
+		},
+		"CA root has been rotated without a root ID": {
+			window: time.Minute,
+			modifyData: func(t *testing.T, data map[string]string) {
+				var extData structs.ExtensionData
+				require.NoError(t, json.Unmarshal([]byte(data[path]), &extData))
+				extData.RootID = ""
+				extData.RootCertPEM = "old-root"
+				d, err := json.Marshal(extData)
+				require.NoError(t, err)
+				data[path] = string(d)
+			},
+			expectedReason: "CA root has been rotated",
+		},
+		"Cluster peers have changed": {
+			window: time.Minute,
+			modifyData: func(t *testing.T, data map[string]string) {
+				var extData structs.ExtensionData
+				require.NoError(t, json.Unmarshal([]byte(data[path]), &extData))
+				extData.Peers = []structs.Peer{{Name: "peer-a", TrustDomain: "11111111-1111-1111-1111-111111111111.consul"}}
+				d, err := json.Marshal(extData)
+				require.NoError(t, err)
+				data[path] = string(d)
+			},
+			expectedReason: "cluster peers have changed",
+		},
+		"Mesh gateways have changed": {
+			window: time.Minute,
+			modifyData: func(t *testing.T, data map[string]string) {
+				var extData structs.ExtensionData
+				require.NoError(t, json.Unmarshal([]byte(data[path]), &extData))
+				extData.MeshGateways = []structs.MeshGateway{{LANAddress: "10.0.0.1:8443"}}
+				d, err := json.Marshal(extData)
+				require.NoError(t, err)
+				data[path] = string(d)
+			},
+			expectedReason: "mesh gateways have changed",
+		},
+		"Extension data is missing": {
+			window: time.Minute,
+			modifyData: func(_ *testing.T, data map[string]string) {
+				delete(data, path)
+			},
+			expectedReason: "extension data is missing",
+		},
+		"Extension data cannot be read": {
+			window:   10000 * time.Hour,
+			storeErr: errors.New("throttled"),
+		},
+		"Invalid certificate": {
+			window: time.Minute,
+			modifyData: func(t *testing.T, data map[string]string) {
+				d, err := json.Marshal(structs.ExtensionData{CertPEM: "invalid"})
+				require.NoError(t, err)
+				data[path] = string(d)
+			},
+			expectedReason: "failed to decode leaf certificate",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			server, err := testutil.NewTestServerConfigT(t, nil)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = server.Stop()
+			})
+			server.WaitForActiveCARoot(t)
+
+			consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+			require.NoError(t, err)
+
+			data := make(map[string]string)
+			env := mockEnvironment(mockLambdaClient(service1), consulClient)
+			env.ExtensionDataPrefix = "/prefix"
+			env.LeafCertRotationWindow = c.window
+			env.Store = mockSSMClient(data)
+
+			require.NoError(t, s1.Reconcile(env))
+			require.Contains(t, data, path)
+			if c.modifyData != nil {
+				c.modifyData(t, data)
+			}
+			if c.storeErr != nil {
+				env.Store = failingStore{ParamStore: env.Store, err: c.storeErr}
+			}
+
+			events, err := env.FullSyncData(context.Background())
+			require.NoError(t, err)
+
+			if c.expectedReason == "" {
+				require.Empty(t, events)
+				return
+			}
+
+			require.Len(t, events, 1)
+			e, ok := events[0].(RotateCertEvent)
+			require.True(t, ok)
+			require.Equal(t, s1.Service, e.Service)
+			require.Contains(t, e.Reason, c.expectedReason)
+
+			// Reconciling the event writes fresh extension data.
+			require.NoError(t, e.Reconcile(env))
+			events, err = env.FullSyncData(context.Background())
+			require.NoError(t, err)
+			if c.window < time.Hour {
+				require.Empty(t, events)
+			}
+		})
+	}
+}
+
+// failingStore is a ParamStore that fails to read any value.
+type failingStore struct {
+	ParamStore
+	err error
+}
+
+func (s failingStore) Get(context.Context, string) (string, error) {
+	return "", s.err
+}
@@ -0,0 +1,96 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// lambdaListStrategyLambda lists functions with the Lambda API and reads the tags of each function with GetFunction.
+	lambdaListStrategyLambda = "lambda"
+	// lambdaListStrategyTagging lists functions and their tags in bulk with the Resource Groups Tagging API.
+	lambdaListStrategyTagging = "tagging"
+
+	lambdaFunctionResourceType = "lambda:function"
+)
+
+// TaggingLambda is a client for listing Lambda functions with the Resource Groups Tagging API.
+// Only functions that have the enabled tag are listed, and their tags are retrieved in bulk,
+// so a full sync does not need to call GetFunction for every function in the region.
+// All other requests, and ListFunctions when the Resource Groups Tagging API fails, are
+// handled by the fallback LambdaAPIClient.
+type TaggingLambda struct {
+	LambdaAPIClient
+
+	taggingClient resourcegroupstaggingapi.GetResourcesAPIClient
+	pageSize      int
+	limiter       *rate.Limiter
+	logger        hclog.Logger
+}
+
+// NewTaggingLambdaClient returns a TaggingLambda client.
+// Requests to the Resource Groups Tagging API are limited to rateLimit requests per second.
+func NewTaggingLambdaClient(cfg *aws.Config, fallback LambdaAPIClient, pageSize int, rateLimit float64, logger hclog.Logger) *TaggingLambda {
+	return &TaggingLambda{
+		LambdaAPIClient: fallback,
+		taggingClient:   resourcegroupstaggingapi.NewFromConfig(*cfg),
+		pageSize:        pageSize,
+		limiter:         newLimiter(rateLimit),
+		logger:          logger,
+	}
+}
+
+// ListFunctions returns a map of LambdaFunction indexed by ARN.
+// Functions that do not have the enabled tag are not included.
+func (c *TaggingLambda) ListFunctions(ctx context.Context) (map[string]LambdaFunction, error) {
+	lambdas, err := c.listTaggedFunctions(ctx)
+	if err != nil {
+		c.logger.Warn("Failed to list functions with the Resource Groups Tagging API, falling back to the Lambda API", "error", err)
+		return c.LambdaAPIClient.ListFunctions(ctx)
+	}
+	return lambdas, nil
+}
+
+func (c *TaggingLambda) listTaggedFunctions(ctx context.Context) (map[string]LambdaFunction, error) {
+	params := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{lambdaFunctionResourceType},
+		TagFilters:          []types.TagFilter{{Key: aws.String(enabledTag)}},
+		ResourcesPerPage:    aws.Int32(int32(c.pageSize)),
+	}
+	paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(c.taggingClient, params)
+	lambdas := make(map[string]LambdaFunction)
+
+	for paginator.HasMorePages() {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range output.ResourceTagMappingList {
+			arn := aws.ToString(r.ResourceARN)
+			fn := LambdaFunction{
+				ARN:  arn,
+				Name: parseFunctionIdentifier(arn).name,
+				Tags: make(map[string]string, len(r.Tags)),
+			}
+			for _, t := range r.Tags {
+				fn.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+			}
+			lambdas[fn.ARN] = fn
+		}
+	}
+
+	return lambdas, nil
+}
@@ -0,0 +1,111 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
+)
+
+func TestTaggingLambda_ListFunctions(t *testing.T) {
+	arn1 := "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234"
+	arn2 := "arn:aws:lambda:us-east-1:111111111111:function:lambda-5678"
+	fallback := mockLambdaClient(UpsertEventPlusMeta{
+		UpsertEvent: UpsertEvent{
+			Service:         structs.Service{Name: "lambda-fallback"},
+			LambdaArguments: LambdaArguments{ARN: "arn:aws:lambda:us-east-1:111111111111:function:lambda-fallback"},
+		},
+		CreateService: true,
+	})
+
+	cases := map[string]struct {
+		pages    []*resourcegroupstaggingapi.GetResourcesOutput
+		err      error
+		expected map[string]LambdaFunction
+	}{
+		"Multiple pages": {
+			pages: []*resourcegroupstaggingapi.GetResourcesOutput{
+				{
+					PaginationToken: aws.String("next"),
+					ResourceTagMappingList: []types.ResourceTagMapping{
+						{
+							ResourceARN: aws.String(arn1),
+							Tags: []types.Tag{
+								{Key: aws.String(enabledTag), Value: aws.String("true")},
+								{Key: aws.String(aliasesTag), Value: aws.String("dev+prod")},
+							},
+						},
+					},
+				},
+				{
+					ResourceTagMappingList: []types.ResourceTagMapping{
+						{
+							ResourceARN: aws.String(arn2),
+							Tags:        []types.Tag{{Key: aws.String(enabledTag), Value: aws.String("false")}},
+						},
+					},
+				},
+			},
+			expected: map[string]LambdaFunction{
+				arn1: {ARN: arn1, Name: "lambda-1234", Tags: map[string]string{enabledTag: "true", aliasesTag: "dev+prod"}},
+				arn2: {ARN: arn2, Name: "lambda-5678", Tags: map[string]string{enabledTag: "false"}},
+			},
+		},
+		"No tagged functions": {
+			pages:    []*resourcegroupstaggingapi.GetResourcesOutput{{}},
+			expected: map[string]LambdaFunction{},
+		},
+		"Fallback on error": {
+			err:      errors.New("access denied"),
+			expected: fallback.Functions,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tagging := &mockTaggingClient{t: t, pages: c.pages, err: c.err}
+			client := &TaggingLambda{
+				LambdaAPIClient: fallback,
+				taggingClient:   tagging,
+				pageSize:        50,
+				limiter:         newLimiter(0),
+				logger:          hclog.NewNullLogger(),
+			}
+
+			fns, err := client.ListFunctions(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, c.expected, fns)
+		})
+	}
+}
+
+type mockTaggingClient struct {
+	t     *testing.T
+	pages []*resourcegroupstaggingapi.GetResourcesOutput
+	err   error
+	idx   int
+}
+
+var _ resourcegroupstaggingapi.GetResourcesAPIClient = (*mockTaggingClient)(nil)
+
+func (m *mockTaggingClient) GetResources(_ context.Context, in *resourcegroupstaggingapi.GetResourcesInput, _ ...func(*resourcegroupstaggingapi.Options)) (*resourcegroupstaggingapi.GetResourcesOutput, error) {
+	require.Equal(m.t, []string{lambdaFunctionResourceType}, in.ResourceTypeFilters)
+	require.Equal(m.t, []types.TagFilter{{Key: aws.String(enabledTag)}}, in.TagFilters)
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	page := m.pages[m.idx]
+	m.idx++
+	return page, nil
+}
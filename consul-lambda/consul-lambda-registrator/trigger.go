@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/hashicorp/consul/api"
@@ -49,6 +50,10 @@ var (
 )
 
 type AWSEvent struct {
+	// Account is the ID of the AWS account that the event occurred in.
+	Account string `json:"account"`
+	// Region is the AWS region that the event occurred in.
+	Region string `json:"region"`
 	Detail Detail `json:"detail"`
 }
 
@@ -67,18 +72,42 @@ type ResponseElements struct {
 type RequestParameters struct {
 	FunctionName string `json:"functionName"`
 	Resource     string `json:"resource"`
+	// Name is the name of the alias for alias events.
+	Name string `json:"name"`
+	// Qualifier is the version or alias to delete for DeleteFunction events.
+	// If it is empty the function and all of its versions and aliases are deleted.
+	Qualifier string `json:"qualifier"`
 }
 
 // AWSEventToEvents converts an AWSEvent to a list of Events required to sync Lambda state with Consul.
 func (env Environment) AWSEventToEvents(ctx context.Context, event AWSEvent) ([]Event, error) {
 	var events []Event
-	var arn string
+	var arn, alias string
 	switch event.Detail.EventName {
 	case "CreateFunction20150331", "CreateFunction":
 		arn = event.Detail.ResponseElements.FunctionArn
 	case "TagResource20170331v2", "TagResource20170331", "TagResource",
 		"UntagResource20170331v2", "UntagResource20170331", "UntagResource":
 		arn = event.Detail.RequestParameters.Resource
+	case "UpdateFunctionConfiguration20150331v2", "UpdateFunctionConfiguration20150331", "UpdateFunctionConfiguration",
+		"CreateAlias20150331", "CreateAlias", "UpdateAlias20150331", "UpdateAlias":
+		arn = event.Detail.RequestParameters.FunctionName
+	case "DeleteFunction20150331", "DeleteFunction":
+		params := event.Detail.RequestParameters
+		if params.FunctionName == "" {
+			return events, errARNUndefined
+		}
+		fn := event.functionIdentifier(params.FunctionName)
+		if params.Qualifier != "" {
+			fn.qualifier = params.Qualifier
+		}
+		return env.deletedFunctionEvents(fn)
+	case "DeleteAlias20150331", "DeleteAlias":
+		arn = event.Detail.RequestParameters.FunctionName
+		alias = event.Detail.RequestParameters.Name
+		if alias == "" {
+			return events, fmt.Errorf("alias name isn't populated")
+		}
 	default:
 		return events, fmt.Errorf("unsupported event kind %s", event.Detail.EventName)
 	}
@@ -89,6 +118,16 @@ func (env Environment) AWSEventToEvents(ctx context.Context, event AWSEvent) ([]
 
 	fn, err := env.Lambda.GetFunction(ctx, arn)
 	if err != nil {
+		if errors.Is(err, errLambdaNotFound) {
+			// The function was deleted before the event was processed so remove anything
+			// that is still registered for it.
+			env.Logger.Info("Lambda function not found, removing its services", "function", arn)
+			fn := event.functionIdentifier(arn)
+			if alias != "" {
+				fn.qualifier = alias
+			}
+			return env.deletedFunctionEvents(fn)
+		}
 		return events, err
 	}
 
@@ -97,11 +136,129 @@ func (env Environment) AWSEventToEvents(ctx context.Context, event AWSEvent) ([]
 		return events, err
 	}
 
+	if alias != "" {
+		// Only the deleted alias needs to be removed from Consul.
+		// The service for the function itself and its remaining aliases are unaffected.
+		if len(lambdaEvents) == 0 {
+			return events, nil
+		}
+		var service structs.Service
+		switch e := lambdaEvents[0].(type) {
+		case UpsertEvent:
+			service = e.Service
+		case DeleteEvent:
+			service = e.Service
+		}
+		if slices.Contains(strings.Split(fn.Tags[aliasesTag], listSeparator), alias) {
+			return append(events, DeleteEvent{service}.AddAlias(alias)), nil
+		}
+
+		// The alias is no longer in the function's aliases tag so only delete the service if it was
+		// registered for the alias. Otherwise a service with the same name that is not managed by the
+		// registrator would be deleted.
+		fnID := event.functionIdentifier(fn.ARN)
+		fnID.qualifier = alias
+		return env.deletedFunctionEvents(fnID)
+	}
+
 	events = append(events, lambdaEvents...)
 
 	return events, nil
 }
 
+// deletedFunctionEvents returns the DeleteEvents for the services in Consul that belong to a Lambda function
+// that no longer exists. The function's tags cannot be read once it has been deleted so the services are found
+// by matching the ARN in their service-defaults config entries against the function's region, account and name.
+// If the function has a qualifier, only the service for that alias is deleted. Services are only registered for
+// aliases so a version qualifier matches no services.
+func (env Environment) deletedFunctionEvents(fn functionIdentifier) ([]Event, error) {
+	var events []Event
+
+	enterpriseMetas, err := env.getEnterpriseMetas()
+	if err != nil {
+		return events, err
+	}
+
+	consulServices, err := env.getConsulServices(enterpriseMetas)
+	if err != nil {
+		return events, err
+	}
+
+	for em, services := range consulServices {
+		for serviceName, svc := range services {
+			if svc.serviceDefaults == nil {
+				continue
+			}
+			arn, _ := lambdaExtensionArguments(svc.serviceDefaults)[arnField].(string)
+			svcFn := parseFunctionIdentifier(arn)
+			if !svcFn.sameFunction(fn) || (fn.qualifier != "" && svcFn.qualifier != fn.qualifier) {
+				continue
+			}
+			events = append(events, DeleteEvent{structs.Service{
+				Name:           serviceName,
+				EnterpriseMeta: structs.NewEnterpriseMeta(em.Partition, em.Namespace),
+			}})
+		}
+	}
+
+	return events, nil
+}
+
+// functionIdentifier identifies a Lambda function and optionally one of its versions or aliases.
+type functionIdentifier struct {
+	region    string
+	account   string
+	name      string
+	qualifier string
+}
+
+// parseFunctionIdentifier parses a Lambda function identifier.
+// The identifier may be a function name, a partial ARN or a full ARN, each with an optional qualifier suffix.
+// The region and account are empty if the identifier does not include them.
+func parseFunctionIdentifier(id string) functionIdentifier {
+	var fn functionIdentifier
+	parts := strings.Split(id, ":")
+	for i, p := range parts {
+		if p == "function" && i+1 < len(parts) {
+			switch i {
+			case 1:
+				// 111111111111:function:name
+				fn.account = parts[0]
+			case 5:
+				// arn:aws:lambda:us-east-1:111111111111:function:name
+				fn.region = parts[3]
+				fn.account = parts[4]
+			}
+			parts = parts[i+1:]
+			break
+		}
+	}
+
+	fn.name = parts[0]
+	if len(parts) > 1 {
+		fn.qualifier = parts[1]
+	}
+	return fn
+}
+
+// functionIdentifier parses the Lambda function identifier from the event. The region and account of the
+// event are used if the identifier does not include them.
+func (e AWSEvent) functionIdentifier(id string) functionIdentifier {
+	fn := parseFunctionIdentifier(id)
+	if fn.region == "" {
+		fn.region = e.Region
+	}
+	if fn.account == "" {
+		fn.account = e.Account
+	}
+	return fn
+}
+
+// sameFunction returns true if both identifiers refer to the same function, ignoring their qualifiers.
+func (fn functionIdentifier) sameFunction(other functionIdentifier) bool {
+	return fn.region == other.region && fn.account == other.account && fn.name == other.name
+}
+
 const (
 	// `,` isn't allowed
 	// https://docs.aws.amazon.com/directoryservice/latest/devguide/API_Tag.html
@@ -231,12 +388,65 @@ func (env Environment) FullSyncData(ctx context.Context) ([]Event, error) {
 	}
 	env.Logger.Debug("retrieved consulServices", "consulServices", consulServices)
 
-	events := env.constructUpsertEvents(lambdas, consulServices)
-	return append(events, env.constructDeleteEvents(lambdas, consulServices)...), nil
+	var drift driftSummary
+	events := env.constructUpsertEvents(lambdas, consulServices, &drift)
+	events = append(events, env.constructDeleteEvents(lambdas, consulServices, &drift)...)
+
+	rotateEvents, err := env.constructRotateCertEvents(ctx, lambdas, events)
+	if err != nil {
+		return nil, err
+	}
+	drift.rotated = len(rotateEvents)
+	events = append(events, rotateEvents...)
+
+	env.Logger.Info("Full sync drift summary",
+		"missing", drift.missing,
+		"drifted", drift.drifted,
+		"disabled", drift.disabled,
+		"stale", drift.stale,
+		"rotated", drift.rotated)
+
+	return events, nil
 }
 
 type eventMap map[structs.EnterpriseMeta]map[string]Event
-type serviceMap map[structs.EnterpriseMeta]map[string]struct{}
+type serviceMap map[structs.EnterpriseMeta]map[string]consulService
+
+// consulService holds the Consul state of a service that is managed by Lambda registrator.
+type consulService struct {
+	// registered is true if the service is registered to Lambda registrator's node.
+	registered bool
+	// serviceDefaults is the service's service-defaults config entry. It is nil if the
+	// config entry does not exist.
+	serviceDefaults *api.ServiceConfigEntry
+}
+
+// drift returns a description of each difference between the service's state in Consul and
+// the state required by the given UpsertEvent. It returns nil if the service is in sync.
+func (s consulService) drift(e UpsertEvent) []string {
+	var reasons []string
+	if !s.registered {
+		reasons = append(reasons, "service is not registered to node")
+	}
+	if s.serviceDefaults == nil {
+		return append(reasons, "service-defaults config entry is missing")
+	}
+	return append(reasons, e.serviceDefaultsDrift(s.serviceDefaults)...)
+}
+
+// driftSummary counts the differences between Lambda and Consul found during a full sync.
+type driftSummary struct {
+	// missing is the number of enabled Lambda services that are not in Consul.
+	missing int
+	// drifted is the number of Lambda services whose Consul state differs from their tags.
+	drifted int
+	// disabled is the number of services in Consul whose Lambda function is no longer enabled.
+	disabled int
+	// stale is the number of services in Consul whose Lambda function no longer exists.
+	stale int
+	// rotated is the number of services whose mTLS leaf certificate needs to be rotated.
+	rotated int
+}
 
 // getLambdas makes requests to the AWS APIs to get data about every Lambda and
 // constructs events to register or deregister those Lambdas with Consul.
@@ -249,7 +459,8 @@ func (env Environment) getLambdas(ctx context.Context) (eventMap, error) {
 		return lambdas, err
 	}
 
-	// TODO: could do this processing concurrently
+	// The Lambda API requests are made concurrently by ListFunctions so the remaining
+	// processing is cheap enough to do serially.
 	for _, fn := range funcs {
 		events, err := env.GetLambdaEvents(fn)
 		if err != nil {
@@ -264,20 +475,14 @@ func (env Environment) getLambdas(ctx context.Context) (eventMap, error) {
 		for _, event := range events {
 			switch e := event.(type) {
 			case UpsertEvent:
-				var em structs.EnterpriseMeta
-				if e.EnterpriseMeta != nil {
-					em = *e.EnterpriseMeta
-				}
+				em := enterpriseMetaOrDefault(e.EnterpriseMeta)
 				if lambdas[em] == nil {
 					lambdas[em] = make(map[string]Event)
 				}
 				lambdas[em][e.Name] = event
 
 			case DeleteEvent:
-				var em structs.EnterpriseMeta
-				if e.EnterpriseMeta != nil {
-					em = *e.EnterpriseMeta
-				}
+				em := enterpriseMetaOrDefault(e.EnterpriseMeta)
 				if lambdas[em] == nil {
 					lambdas[em] = make(map[string]Event)
 				}
@@ -331,12 +536,38 @@ func (env Environment) getConsulServices(enterpriseMetas []structs.EnterpriseMet
 			return nil, err
 		}
 		env.Logger.Debug("got service catalog", "services", services)
-		consulServices[em] = make(map[string]struct{})
+
+		nodeServices, _, err := env.ConsulClient.Catalog().NodeServiceList(env.NodeName, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		registered := make(map[string]struct{})
+		if nodeServices != nil {
+			for _, s := range nodeServices.Services {
+				if s.ID == s.Service && hasManagedLambdaTag(s.Tags) {
+					registered[s.Service] = struct{}{}
+				}
+			}
+		}
+
+		entries, _, err := env.ConsulClient.ConfigEntries().List(api.ServiceDefaults, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		serviceDefaults := make(map[string]*api.ServiceConfigEntry, len(entries))
+		for _, entry := range entries {
+			if sd, ok := entry.(*api.ServiceConfigEntry); ok {
+				serviceDefaults[sd.Name] = sd
+			}
+		}
+
+		consulServices[em] = make(map[string]consulService)
 		for serviceName, tags := range services {
-			for _, t := range tags {
-				if managedLambdaTag == t {
-					consulServices[em][serviceName] = struct{}{}
-					break
+			if hasManagedLambdaTag(tags) {
+				_, ok := registered[serviceName]
+				consulServices[em][serviceName] = consulService{
+					registered:      ok,
+					serviceDefaults: serviceDefaults[serviceName],
 				}
 			}
 		}
@@ -345,27 +576,42 @@ func (env Environment) getConsulServices(enterpriseMetas []structs.EnterpriseMet
 	return consulServices, nil
 }
 
+// hasManagedLambdaTag returns true if the given service tags mark the service as managed by Lambda registrator.
+func hasManagedLambdaTag(tags []string) bool {
+	for _, t := range tags {
+		if managedLambdaTag == t {
+			return true
+		}
+	}
+	return false
+}
+
 // constructUpsertEvents determines which upsert events need to be processed to
 // synchronize Consul with Lambda.
-func (env Environment) constructUpsertEvents(lambdas eventMap, consulServices serviceMap) []Event {
+// An upsert event is required for each Lambda service that is either missing from Consul
+// or whose Consul state has drifted from the state described by the function's tags.
+func (env Environment) constructUpsertEvents(lambdas eventMap, consulServices serviceMap, drift *driftSummary) []Event {
 	var events []Event
 
 	for enterpriseMeta, lambdaEvents := range lambdas {
 		for serviceName, event := range lambdaEvents {
 			switch e := event.(type) {
 			case UpsertEvent:
-				if consulEvents, ok := consulServices[enterpriseMeta]; ok {
-					if _, ok := consulEvents[serviceName]; !ok {
-						events = append(events, e)
-					}
-				} else {
+				svc, ok := consulServices[enterpriseMeta][serviceName]
+				if !ok {
+					drift.missing++
+					events = append(events, e)
+					continue
+				}
+				if reasons := svc.drift(e); len(reasons) > 0 {
+					env.Logger.Info("Lambda service has drifted from Consul", "service", serviceName, "arn", e.ARN, "reasons", reasons)
+					drift.drifted++
 					events = append(events, e)
 				}
 			case DeleteEvent:
-				if consulEvents, ok := consulServices[enterpriseMeta]; ok {
-					if _, ok := consulEvents[serviceName]; ok {
-						events = append(events, e)
-					}
+				if _, ok := consulServices[enterpriseMeta][serviceName]; ok {
+					drift.disabled++
+					events = append(events, e)
 				}
 			}
 		}
@@ -374,9 +620,9 @@ func (env Environment) constructUpsertEvents(lambdas eventMap, consulServices se
 	return events
 }
 
-// constructUpsertEvents determines which delete events need to be processed to
+// constructDeleteEvents determines which delete events need to be processed to
 // synchronize Consul with Lambda.
-func (env Environment) constructDeleteEvents(lambdas eventMap, consulServices serviceMap) []Event {
+func (env Environment) constructDeleteEvents(lambdas eventMap, consulServices serviceMap, drift *driftSummary) []Event {
 	var events []Event
 	// Constructing delete events for services that need to be deregistered in Consul
 	for enterpriseMeta, consulService := range consulServices {
@@ -384,11 +630,8 @@ func (env Environment) constructDeleteEvents(lambdas eventMap, consulServices se
 			deleteEvent := DeleteEvent{structs.Service{
 				Name:           serviceName,
 				EnterpriseMeta: structs.NewEnterpriseMeta(enterpriseMeta.Partition, enterpriseMeta.Namespace)}}
-			if lambdaEvents, ok := lambdas[enterpriseMeta]; ok {
-				if _, ok := lambdaEvents[serviceName]; !ok {
-					events = append(events, deleteEvent)
-				}
-			} else {
+			if _, ok := lambdas[enterpriseMeta][serviceName]; !ok {
+				drift.stale++
 				events = append(events, deleteEvent)
 			}
 		}
@@ -44,7 +44,8 @@ func TestAWSEventToEvents(t *testing.T) {
 		return e
 	}
 
-	cases := []string{"tag_resource", "untag_resource", "create_function"}
+	cases := []string{"tag_resource", "untag_resource", "create_function",
+		"create_alias", "update_alias", "update_function_configuration"}
 
 	for _, c := range cases {
 		t.Run(c, func(t *testing.T) {
@@ -68,6 +69,150 @@ func TestAWSEventToEvents(t *testing.T) {
 	})
 }
 
+func TestAWSEventToEvents_Delete(t *testing.T) {
+	arn := "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234"
+	s1 := UpsertEvent{
+		Service: structs.Service{Name: "lambda-1234"},
+		LambdaArguments: LambdaArguments{
+			ARN:            arn,
+			InvocationMode: synchronousInvocationMode,
+		},
+	}
+	s1prod := s1.AddAlias("prod")
+	s1dev := s1.AddAlias("dev")
+	s2 := UpsertEvent{
+		Service: structs.Service{Name: "lambda-5678"},
+		LambdaArguments: LambdaArguments{
+			ARN:            "arn:aws:lambda:us-east-1:111111111111:function:lambda-5678",
+			InvocationMode: synchronousInvocationMode,
+		},
+	}
+	// s3 is a function with the same name in another account.
+	s3 := UpsertEvent{
+		Service: structs.Service{Name: "lambda-1234-other-account"},
+		LambdaArguments: LambdaArguments{
+			ARN:            "arn:aws:lambda:us-east-1:222222222222:function:lambda-1234",
+			InvocationMode: synchronousInvocationMode,
+		},
+	}
+
+	deleteEvent := func(e UpsertEvent) Event {
+		return DeleteEvent{structs.Service{Name: e.Name}}
+	}
+
+	cases := map[string]struct {
+		fixture     string
+		lambdaState []UpsertEventPlusMeta
+		// unmanagedServices are registered in Consul without the registrator.
+		unmanagedServices []string
+		expectedEvents    []Event
+	}{
+		"Delete function": {
+			fixture:        "delete_function",
+			expectedEvents: []Event{deleteEvent(s1), deleteEvent(s1prod), deleteEvent(s1dev)},
+		},
+		"Delete function alias": {
+			fixture:        "delete_function_qualifier",
+			expectedEvents: []Event{deleteEvent(s1prod)},
+		},
+		"Delete function version": {
+			fixture: "delete_function_version",
+		},
+		"Delete alias of an existing function": {
+			fixture: "delete_alias",
+			lambdaState: []UpsertEventPlusMeta{
+				{UpsertEvent: s1, Aliases: []string{"dev", "prod"}, CreateService: true},
+			},
+			expectedEvents: []Event{deleteEvent(s1prod)},
+		},
+		"Delete alias that was removed from the aliases tag": {
+			fixture: "delete_alias",
+			lambdaState: []UpsertEventPlusMeta{
+				{UpsertEvent: s1, Aliases: []string{"dev"}, CreateService: true},
+			},
+			expectedEvents: []Event{deleteEvent(s1prod)},
+		},
+		"Delete alias that was never registered": {
+			fixture: "delete_alias_unregistered",
+			lambdaState: []UpsertEventPlusMeta{
+				{UpsertEvent: s1, Aliases: []string{"dev"}, CreateService: true},
+			},
+			unmanagedServices: []string{"lambda-1234-staging"},
+		},
+		"Delete alias of a deleted function": {
+			fixture:        "delete_alias",
+			expectedEvents: []Event{deleteEvent(s1prod)},
+		},
+		"Update a deleted function": {
+			fixture:        "update_function_configuration",
+			expectedEvents: []Event{deleteEvent(s1), deleteEvent(s1prod), deleteEvent(s1dev)},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			server, err := testutil.NewTestServerConfigT(t, nil)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = server.Stop()
+			})
+
+			consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+			require.NoError(t, err)
+
+			env := mockEnvironment(mockLambdaClient(c.lambdaState...), consulClient)
+			for _, e := range []UpsertEvent{s1, s1prod, s1dev, s2, s3} {
+				require.NoError(t, e.Reconcile(env))
+			}
+			for _, name := range c.unmanagedServices {
+				_, err := consulClient.Catalog().Register(&api.CatalogRegistration{
+					Node:    "node",
+					Address: "127.0.0.1",
+					Service: &api.AgentService{Service: name},
+				}, nil)
+				require.NoError(t, err)
+			}
+
+			d, err := os.ReadFile("./fixtures/" + c.fixture + ".json")
+			require.NoError(t, err)
+			var event AWSEvent
+			require.NoError(t, json.Unmarshal(d, &event))
+
+			events, err := env.AWSEventToEvents(context.Background(), event)
+			require.NoError(t, err)
+			require.ElementsMatch(t, c.expectedEvents, events)
+		})
+	}
+}
+
+func TestParseFunctionIdentifier(t *testing.T) {
+	cases := map[string]functionIdentifier{
+		"lambda-1234":      {name: "lambda-1234"},
+		"lambda-1234:prod": {name: "lambda-1234", qualifier: "prod"},
+		"111111111111:function:lambda-1234": {
+			account: "111111111111",
+			name:    "lambda-1234",
+		},
+		"arn:aws:lambda:us-east-1:111111111111:function:lambda-1234": {
+			region:  "us-east-1",
+			account: "111111111111",
+			name:    "lambda-1234",
+		},
+		"arn:aws:lambda:us-east-1:111111111111:function:lambda-1234:prod": {
+			region:    "us-east-1",
+			account:   "111111111111",
+			name:      "lambda-1234",
+			qualifier: "prod",
+		},
+	}
+
+	for id, expected := range cases {
+		t.Run(id, func(t *testing.T) {
+			require.Equal(t, expected, parseFunctionIdentifier(id))
+		})
+	}
+}
+
 func TestGetLambdaData(t *testing.T) {
 	arn := "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234"
 	makeService := func(enterprise bool, alias, dc string) UpsertEvent {
@@ -283,6 +428,11 @@ func TestFullSyncData(t *testing.T) {
 		CreateService: true,
 	}
 
+	passthroughService1 := service1
+	passthroughService1.PayloadPassthrough = true
+	asyncService1 := service1
+	asyncService1.InvocationMode = asynchronousInvocationMode
+
 	otherDCService1 := service1
 	otherDCService1.Datacenter = "dc2"
 	ossEnterpriseTaggedService := UpsertEventPlusMeta{
@@ -307,6 +457,8 @@ func TestFullSyncData(t *testing.T) {
 		ExpectedEvents  []Event
 		Partitions      []string
 		Datacenter      string
+		// Remove the service-defaults config entries after seeding Consul
+		DeleteServiceDefaults bool
 	}
 
 	cases := map[string]*caseData{
@@ -335,6 +487,27 @@ func TestFullSyncData(t *testing.T) {
 			SeedLambdaState: []UpsertEventPlusMeta{service1WithAlias},
 			ExpectedEvents:  []Event{s1, s1dev, s1prod},
 		},
+		"Ignore services that are in sync": {
+			SeedConsulState: []UpsertEventPlusMeta{service1},
+			SeedLambdaState: []UpsertEventPlusMeta{service1},
+			ExpectedEvents:  []Event{},
+		},
+		"Update a service when payload passthrough changes": {
+			SeedConsulState: []UpsertEventPlusMeta{service1},
+			SeedLambdaState: []UpsertEventPlusMeta{passthroughService1},
+			ExpectedEvents:  []Event{passthroughService1.UpsertEvent},
+		},
+		"Update a service when invocation mode changes": {
+			SeedConsulState: []UpsertEventPlusMeta{service1},
+			SeedLambdaState: []UpsertEventPlusMeta{asyncService1},
+			ExpectedEvents:  []Event{asyncService1.UpsertEvent},
+		},
+		"Update a service when service defaults are missing": {
+			SeedConsulState:       []UpsertEventPlusMeta{service1},
+			SeedLambdaState:       []UpsertEventPlusMeta{service1},
+			DeleteServiceDefaults: true,
+			ExpectedEvents:        []Event{s1},
+		},
 	}
 
 	if enterprise {
@@ -392,6 +565,11 @@ func TestFullSyncData(t *testing.T) {
 			for _, e := range c.SeedConsulState {
 				err := e.Reconcile(env)
 				require.NoError(t, err)
+
+				if c.DeleteServiceDefaults {
+					_, err = consulClient.ConfigEntries().Delete(api.ServiceDefaults, e.Name, WriteOptions(e.Service))
+					require.NoError(t, err)
+				}
 			}
 
 			events, err := env.FullSyncData(ctx)
@@ -4,11 +4,11 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
 )
 
@@ -18,6 +18,9 @@ const (
 	arnField                = "arn"
 	invocationModeField     = "invocationMode"
 	payloadPassthroughField = "payloadPassthrough"
+
+	// functionARNTag is applied to the extension data to identify the Lambda function that it belongs to.
+	functionARNTag = prefix + "/function-arn"
 )
 
 // UpsertEvent struct holds data for an event that triggers the upserting of a Lambda function.
@@ -58,6 +61,33 @@ func (e LambdaArguments) toConsulServiceConfigEntry(name string) *api.ServiceCon
 	return serviceDefaults
 }
 
+// serviceDefaultsDrift compares the given service-defaults config entry to the entry generated by
+// toConsulServiceConfigEntry and returns a description of each difference.
+// It returns nil if the config entry is up to date.
+func (e LambdaArguments) serviceDefaultsDrift(entry *api.ServiceConfigEntry) []string {
+	var reasons []string
+	if entry.Protocol != "http" {
+		reasons = append(reasons, fmt.Sprintf("protocol is %q", entry.Protocol))
+	}
+
+	args := lambdaExtensionArguments(entry)
+	if args == nil {
+		return append(reasons, "lambda envoy extension is missing")
+	}
+
+	if arn, _ := args[arnField].(string); arn != e.ARN {
+		reasons = append(reasons, fmt.Sprintf("%s changed from %q to %q", arnField, arn, e.ARN))
+	}
+	if mode, _ := args[invocationModeField].(string); mode != e.InvocationMode {
+		reasons = append(reasons, fmt.Sprintf("%s changed from %q to %q", invocationModeField, mode, e.InvocationMode))
+	}
+	if passthrough, _ := args[payloadPassthroughField].(bool); passthrough != e.PayloadPassthrough {
+		reasons = append(reasons, fmt.Sprintf("%s changed from %t to %t", payloadPassthroughField, passthrough, e.PayloadPassthrough))
+	}
+
+	return reasons
+}
+
 // Identifier returns the ARN of the Lambda function being upserted.
 func (e UpsertEvent) Identifier() string {
 	return e.ARN
@@ -80,7 +110,7 @@ func (e UpsertEvent) Reconcile(env Environment) error {
 		return err
 	}
 
-	return env.upsertTLSData(e)
+	return env.upsertTLSData(e.Service, e.ARN)
 }
 
 // AddAlias sets the UpserEvent Name and ARN by appending on the alias in the form `-alias`
@@ -130,53 +160,43 @@ func (env Environment) storeServiceDefaults(e UpsertEvent) error {
 	return nil
 }
 
-func (env Environment) upsertTLSData(e UpsertEvent) error {
+// upsertTLSData writes the extension data with the mTLS material for the service of the Lambda function with the given ARN.
+func (env Environment) upsertTLSData(e structs.Service, arn string) error {
 	if !env.IsManagingTLS() {
 		return nil
 	}
 
 	env.Logger.Debug("upserting mTLS data", "service", e.Name)
 
-	// Retrieve Consul root CA
-	// TODO: Should optimize this flow so that we only request the root CA once, not for every service.
-	// TODO: Retrieve the root CA IFF it is expiring "soon".
-	caRootList, _, err := env.ConsulClient.Agent().ConnectCARoots(nil)
+	caRootList, caRoot, err := env.activeCARoot()
 	if err != nil {
-		return fmt.Errorf("failed to retrieve Consul root CA: %w", err)
+		return err
 	}
 
-	// Use the first active root CA cert
-	var caRoot *api.CARoot
-	for _, root := range caRootList.Roots {
-		if root.ID == caRootList.ActiveRootID {
-			caRoot = root
-			break
-		}
+	// Retrieve the leaf for this service
+	certPEM, keyPEM, err := env.leafCert(e, caRootList.TrustDomain)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve leaf cert for %s: %w", e.Name, err)
 	}
-	if caRoot == nil {
-		return fmt.Errorf("failed to find an active CA root cert: %w", err)
+
+	peers, err := env.activePeers(e)
+	if err != nil {
+		return err
 	}
 
-	// Retrieve the leaf for this service
-	// Note: Agent API calls should not include partition/namespace in QueryOptions
-	// as the agent operates within its own partition context. Including them
-	// causes "request targets partition does not match agent partition" errors.
-	// This means the leaf cert SPIFFE ID will always be scoped to the agent's
-	// own partition (default). Lambda-to-mesh functions must therefore be registered
-	// in the default partition for the cert identity to match intentions.
-	// TODO: Use the gRPC ConnectCA.Sign endpoint to generate CSRs with the
-	// correct SPIFFE ID for services in non-default partitions.
-	leafCert, _, err := env.ConsulClient.Agent().ConnectCALeaf(e.Name, nil)
+	meshGateways, err := env.meshGateways(e)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve leaf cert for %s: %w", e.Name, err)
+		return err
 	}
 
 	extData, err := json.Marshal(structs.ExtensionData{
-		PrivateKeyPEM: leafCert.PrivateKeyPEM,
-		CertPEM:       leafCert.CertPEM,
+		PrivateKeyPEM: keyPEM,
+		CertPEM:       certPEM,
 		RootCertPEM:   caRoot.RootCertPEM,
+		RootID:        caRoot.ID,
 		TrustDomain:   caRootList.TrustDomain,
-		// TODO: cluster peering support
+		Peers:         peers,
+		MeshGateways:  meshGateways,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to marshal extension data: %w", err)
@@ -191,11 +211,70 @@ func (env Environment) upsertTLSData(e UpsertEvent) error {
 	if e.EnterpriseMeta != nil {
 		service.EnterpriseMeta = e.EnterpriseMeta
 	}
-	path := fmt.Sprintf("%s%s", env.ExtensionDataPrefix, service.ExtensionPath())
+	path := env.extensionDataPath(service)
 
-	// TODO: do we need to pass a context in here?.. like from the lambda entrypoint
-	// so that this call can be canceled if necessary.
-	return env.Store.Set(context.Background(), path, string(extData))
+	opts := []client.SetOption{client.WithTags(map[string]string{
+		functionARNTag: arn,
+		partitionTag:   e.PartitionOrDefault(),
+		namespaceTag:   e.NamespaceOrDefault(),
+	})}
+	if env.ExtensionDataExpiration {
+		// The extension data expires with the leaf certificate so that a function can never use an expired certificate.
+		cert, err := parseLeafCert(certPEM)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, client.WithExpiration(cert.NotAfter))
+	}
+
+	return env.Store.Set(env.context(), path, string(extData), opts...)
+}
+
+// activeCARoot returns the Consul CA roots and the active root CA cert.
+// The roots are only retrieved from Consul once for each Environment.
+func (env Environment) activeCARoot() (*api.CARootList, *api.CARoot, error) {
+	if env.cache != nil {
+		env.cache.mu.Lock()
+		defer env.cache.mu.Unlock()
+		if env.cache.roots != nil {
+			return env.cache.roots, env.cache.active, nil
+		}
+	}
+
+	// Retrieve Consul root CA
+	caRootList, _, err := env.ConsulClient.Agent().ConnectCARoots(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve Consul root CA: %w", err)
+	}
+
+	// Use the first active root CA cert
+	var caRoot *api.CARoot
+	for _, root := range caRootList.Roots {
+		if root.ID == caRootList.ActiveRootID {
+			caRoot = root
+			break
+		}
+	}
+	if caRoot == nil {
+		return nil, nil, fmt.Errorf("failed to find an active CA root cert")
+	}
+
+	if env.cache != nil {
+		env.cache.roots = caRootList
+		env.cache.active = caRoot
+	}
+	return caRootList, caRoot, nil
+}
+
+// lambdaExtensionArguments returns the arguments of the Lambda Envoy extension in the given
+// service-defaults config entry or nil if the extension is not configured.
+func lambdaExtensionArguments(entry *api.ServiceConfigEntry) map[string]interface{} {
+	for _, ext := range entry.EnvoyExtensions {
+		if ext.Name == api.BuiltinAWSLambdaExtension {
+			return ext.Arguments
+		}
+	}
+	return nil
 }
 
 // QueryOptions takes in a structs.Service and returns a pointer to an api.QueryOptions struct.
@@ -4,11 +4,15 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/client"
 	"github.com/hashicorp/terraform-aws-consul-lambda/consul-lambda/structs"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUpsertEvent_Identifier(t *testing.T) {
@@ -61,3 +65,89 @@ func TestWriteOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestLambdaArguments_ServiceDefaultsDrift(t *testing.T) {
+	args := LambdaArguments{
+		ARN:                "arn",
+		PayloadPassthrough: true,
+		InvocationMode:     synchronousInvocationMode,
+	}
+
+	testCases := []struct {
+		name     string
+		entry    *api.ServiceConfigEntry
+		expected []string
+	}{
+		{
+			name:  "in-sync",
+			entry: args.toConsulServiceConfigEntry("svc"),
+		},
+		{
+			name: "changed-arguments",
+			entry: LambdaArguments{
+				ARN:            "other-arn",
+				InvocationMode: asynchronousInvocationMode,
+			}.toConsulServiceConfigEntry("svc"),
+			expected: []string{
+				`arn changed from "other-arn" to "arn"`,
+				`invocationMode changed from "ASYNCHRONOUS" to "SYNCHRONOUS"`,
+				"payloadPassthrough changed from false to true",
+			},
+		},
+		{
+			name:     "missing-extension",
+			entry:    &api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "svc", Protocol: "tcp"},
+			expected: []string{`protocol is "tcp"`, "lambda envoy extension is missing"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, args.serviceDefaultsDrift(tc.entry))
+		})
+	}
+}
+
+func TestUpsertTLSData_SetOptions(t *testing.T) {
+	server, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+	server.WaitForActiveCARoot(t)
+
+	consulClient, err := api.NewClient(&api.Config{Address: server.HTTPAddr})
+	require.NoError(t, err)
+
+	arn := "arn:aws:lambda:us-east-1:111111111111:function:lambda-1234"
+	service := structs.Service{Name: "lambda-1234"}
+	path := "/prefix/default/default/lambda-1234"
+	expectedTags := map[string]string{
+		functionARNTag: arn,
+		partitionTag:   "default",
+		namespaceTag:   "default",
+	}
+
+	for _, expiration := range []bool{false, true} {
+		data := make(map[string]string)
+		options := make(map[string]client.SetOptions)
+		env := mockEnvironment(mockLambdaClient(), consulClient)
+		env.ExtensionDataPrefix = "/prefix"
+		env.ExtensionDataExpiration = expiration
+		env.Store = mockSSM{mappings: data, options: options}
+
+		require.NoError(t, env.upsertTLSData(service, arn))
+		require.Equal(t, expectedTags, options[path].Tags)
+
+		if !expiration {
+			require.True(t, options[path].Expiration.IsZero())
+			continue
+		}
+
+		var extData structs.ExtensionData
+		require.NoError(t, json.Unmarshal([]byte(data[path]), &extData))
+		cert, err := parseLeafCert(extData.CertPEM)
+		require.NoError(t, err)
+		require.Equal(t, cert.NotAfter, options[path].Expiration)
+	}
+}
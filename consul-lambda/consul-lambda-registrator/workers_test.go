@@ -0,0 +1,78 @@
+// Copyright IBM Corp. 2022, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEach(t *testing.T) {
+	cases := map[string]struct {
+		n       int
+		workers int
+		max     int32
+	}{
+		"No work":            {n: 0, workers: 4, max: 0},
+		"Fewer workers":      {n: 20, workers: 4, max: 4},
+		"More workers":       {n: 3, workers: 10, max: 3},
+		"Serial":             {n: 5, workers: 1, max: 1},
+		"Invalid worker cnt": {n: 5, workers: 0, max: 1},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var active, max int32
+			seen := make([]bool, c.n)
+			forEach(c.n, c.workers, func(i int) {
+				cur := atomic.AddInt32(&active, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if cur <= m || atomic.CompareAndSwapInt32(&max, m, cur) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				seen[i] = true
+				atomic.AddInt32(&active, -1)
+			})
+
+			require.Equal(t, c.max, max)
+			for i := range seen {
+				require.True(t, seen[i], "index %d was not processed", i)
+			}
+		})
+	}
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: &rateLimitedTransport{
+		limiter: newLimiter(20),
+		next:    http.DefaultTransport,
+	}}
+
+	// The first 20 requests are allowed by the burst and the remaining 10 requests
+	// must wait for the limiter at 20 requests per second.
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestNewLimiter(t *testing.T) {
+	require.True(t, newLimiter(0).Allow())
+	require.Equal(t, 1, newLimiter(0.5).Burst())
+	require.Equal(t, 10, newLimiter(10).Burst())
+}
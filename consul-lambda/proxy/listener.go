@@ -33,6 +33,10 @@ type Listener struct {
 	listener     net.Listener
 
 	connWG sync.WaitGroup
+
+	// connsLock guards access to the conns field
+	connsLock sync.Mutex
+	conns     map[*Conn]struct{}
 }
 
 // NewListener returns a Listener setup to listen for public mTLS
@@ -44,6 +48,7 @@ func NewListener(cfg *Config) *Listener {
 		stopChan:      make(chan struct{}),
 		listeningChan: make(chan struct{}),
 		errChan:       make(chan error, errBufSize),
+		conns:         make(map[*Conn]struct{}),
 	}
 }
 
@@ -78,7 +83,16 @@ func (l *Listener) Serve() error {
 			return err
 		}
 
+		// Track the conn under the stopLock so that it is either closed here or waited for by Close.
+		l.stopLock.Lock()
+		if atomic.LoadInt32(&l.stopFlag) == 1 {
+			l.stopLock.Unlock()
+			conn.Close()
+			return nil
+		}
 		l.connWG.Add(1)
+		l.stopLock.Unlock()
+
 		go l.handleConn(conn)
 	}
 }
@@ -106,6 +120,9 @@ func (l *Listener) handleConn(src net.Conn) {
 	conn := NewConn(src, dst)
 	defer conn.Close()
 
+	l.trackConn(conn)
+	defer l.untrackConn(conn)
+
 	connStop := make(chan struct{})
 
 	// Run another goroutine to copy the bytes.
@@ -131,11 +148,11 @@ func (l *Listener) handleConn(src net.Conn) {
 // Close terminates the listener and all active connections.
 func (l *Listener) Close() {
 	l.stopLock.Lock()
-	defer l.stopLock.Unlock()
 
 	// Prevent the listener from being started.
 	oldFlag := atomic.SwapInt32(&l.stopFlag, 1)
 	if oldFlag != 0 {
+		l.stopLock.Unlock()
 		return
 	}
 
@@ -149,11 +166,22 @@ func (l *Listener) Close() {
 
 	// Stop sending errors.
 	close(l.errChan)
+	l.stopLock.Unlock()
 
-	// Wait for all conns to close
+	// Wait for all conns to close. The stopLock must not be held because conns acquire it to send errors.
 	l.connWG.Wait()
 }
 
+// CloseConns closes all active connections without closing the listener.
+// The listener continues to accept new connections.
+func (l *Listener) CloseConns() {
+	l.connsLock.Lock()
+	defer l.connsLock.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
 // Errors returns a channel that the listener writes errors to.
 // The channel is closed when the listener is closed.
 func (l *Listener) Errors() <-chan error {
@@ -177,6 +205,18 @@ func (l *Listener) getListener() net.Listener {
 	return l.listener
 }
 
+func (l *Listener) trackConn(conn *Conn) {
+	l.connsLock.Lock()
+	defer l.connsLock.Unlock()
+	l.conns[conn] = struct{}{}
+}
+
+func (l *Listener) untrackConn(conn *Conn) {
+	l.connsLock.Lock()
+	defer l.connsLock.Unlock()
+	delete(l.conns, conn)
+}
+
 func (l *Listener) sendError(err error) {
 	// Grab the stopLock to ensure that we don't ever try to send at the same time that Close is called.
 	l.stopLock.Lock()
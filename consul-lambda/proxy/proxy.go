@@ -108,6 +108,16 @@ func (s *Server) Wait() <-chan struct{} {
 	return s.waitChan
 }
 
+// CloseConns closes all active connections on all listeners.
+// The listeners remain open and continue to accept new connections.
+func (s *Server) CloseConns() {
+	s.lmu.Lock()
+	defer s.lmu.Unlock()
+	for _, l := range s.listeners {
+		l.CloseConns()
+	}
+}
+
 // Close shuts down the proxy and closes all active connections and listeners.
 func (s *Server) Close() {
 	s.lmu.Lock()
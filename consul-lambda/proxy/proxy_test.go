@@ -179,6 +179,49 @@ func TestProxyDialError(t *testing.T) {
 	wg.Wait()
 }
 
+// TestProxyCloseConns tests that active connections are closed while the listener keeps accepting new connections.
+func TestProxyCloseConns(t *testing.T) {
+	server, err := NewTCPServer(nil)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	listenFunc, addr := makeListenFunc(t)
+	dialFunc := func() (net.Conn, error) {
+		return net.Dial("tcp", server.Listener.Addr().String())
+	}
+	cfg := []*proxy.Config{{ListenFunc: listenFunc, DialFunc: dialFunc}}
+
+	// Create and start the proxy
+	p := proxy.New(hclog.NewNullLogger(), cfg...)
+	t.Cleanup(func() { p.Close() })
+	go p.Serve()
+
+	// Wait for the proxy to be ready before sending it requests.
+	<-p.Wait()
+
+	// Open a connection and make sure it is proxied before closing the active connections.
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+
+	p.CloseConns()
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(b)
+	require.ErrorIs(t, err, io.EOF)
+
+	// New connections are still accepted.
+	c := tcpClient{}
+	s, err := c.request(addr, "hello")
+	require.NoError(t, err)
+	require.Equal(t, "hello", s)
+}
+
 func makeListenFunc(t *testing.T) (func() (net.Listener, error), string) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -13,11 +13,22 @@ type ExtensionData struct {
 	CertPEM string `json:"certPEM"`
 	// RootCertPEM is the TLS root CA certificate in PEM format.
 	RootCertPEM string `json:"rootCertPEM"`
+	// RootID is the ID of the Consul CA root that was active when the certificate was issued.
+	RootID string `json:"rootID,omitempty"`
 	// TrustDomain is the trusted domain that the service belongs to.
 	TrustDomain string `json:"trustDomain"`
-	// Peers is the list of peers.
-	// TODO: Add support for cluster peering.
+	// Peers is the list of established cluster peers.
 	Peers []Peer `json:"peers,omitempty"`
+	// MeshGateways is the list of healthy mesh gateways in the service's datacenter and partition.
+	MeshGateways []MeshGateway `json:"meshGateways,omitempty"`
+}
+
+// MeshGateway holds the addresses of a mesh gateway.
+type MeshGateway struct {
+	// LANAddress is the host:port address of the mesh gateway within its datacenter.
+	LANAddress string `json:"lanAddress,omitempty"`
+	// WANAddress is the host:port address of the mesh gateway that is reachable from other datacenters.
+	WANAddress string `json:"wanAddress,omitempty"`
 }
 
 // Peer holds the information for a Consul peer.
@@ -26,6 +37,18 @@ type Peer struct {
 	Name string `json:"name"`
 	// TrustDomain is the trusted domain of the peer.
 	TrustDomain string `json:"trustDomain"`
+	// RootCertPEMs are the peer's TLS root CA certificates in PEM format.
+	RootCertPEMs []string `json:"rootCertPEMs,omitempty"`
+}
+
+// Peer returns the peer with the given name.
+func (x ExtensionData) Peer(name string) (Peer, bool) {
+	for _, p := range x.Peers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Peer{}, false
 }
 
 func (x ExtensionData) Equals(y ExtensionData) bool {
@@ -5,17 +5,27 @@ package structs
 
 import (
 	"fmt"
+	"net"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	internal        = "internal"
 	version         = "v1"
 	internalVersion = internal + "-" + version
+	external        = "external"
+
+	// peerSuffix marks the last name component of an upstream as the name of a cluster peer.
+	peerSuffix = "peer"
 )
 
+// validSubset matches the service subset names that Consul accepts in a service-resolver.
+var validSubset = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
 type EnterpriseMeta struct {
 	Namespace string
 	Partition string
@@ -40,11 +50,63 @@ type Service struct {
 	Port        int
 	Datacenter  string
 	TrustDomain string
-	Subset      string
+	// Subset is the name of the service subset, as defined by a service-resolver config entry,
+	// that connections to the service are routed to.
+	Subset string
+	// Peer is the name of the cluster peer that the service is imported from.
+	Peer string
+	// BindAddress is the local IP address that the listener for the upstream binds to.
+	BindAddress string
+	// SocketPath is the path of the Unix domain socket that the listener for the upstream binds to.
+	// It is used instead of the port and bind address.
+	SocketPath string
+	// Protocol is the protocol that the function uses to call the upstream: "tcp", the default, or "http".
+	// Requests to HTTP upstreams are parsed so that timeouts and retries can be applied to them.
+	Protocol string
+	// RequestTimeout is the timeout for each request to an HTTP upstream, including retries.
+	// Zero means no timeout.
+	RequestTimeout time.Duration
+	// Retries is the number of times that an idempotent request to an HTTP upstream is retried
+	// when the connection to the upstream fails or the upstream responds with 503 Service Unavailable.
+	Retries int
 }
 
-// ParseUpstream parses a string in unlabeled upstream format into a Service instance.
+// Upstream protocols.
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolHTTP = "http"
+)
+
+// Fields of the labeled upstream format.
+const (
+	labelService    = "service"
+	labelNamespace  = "namespace"
+	labelPartition  = "partition"
+	labelDatacenter = "datacenter"
+	labelPeer       = "peer"
+	labelSubset     = "subset"
+	labelPort       = "port"
+	labelBind       = "bind"
+	labelSocket     = "socket"
+	labelProtocol   = "protocol"
+	labelTimeout    = "timeout"
+	labelRetries    = "retries"
+)
+
+// ParseUpstream parses a string in labeled or unlabeled upstream format into a Service instance.
+//
+// The unlabeled format is `name[.namespace[.partition]]:port[:datacenter]` for local services and
+// `name.namespace.peer-name.peer:port` for services imported from a cluster peer. The `.peer` suffix is
+// required because `name.namespace.peer-name:port` cannot be distinguished from a service in a partition.
+//
+// Service subsets can only be selected with the labeled format. The labeled format is a comma-separated list of `field=value` pairs, for example
+// `service=api,namespace=ns1,partition=ap1,datacenter=dc2,subset=v2,port=1234,bind=127.0.0.1`.
+// The service field and either the port or socket field are required. See parseLabeledUpstream for the supported fields.
 func ParseUpstream(s string) (Service, error) {
+	if strings.Contains(s, "=") {
+		return parseLabeledUpstream(s)
+	}
+
 	var upstream Service
 	var err error
 
@@ -68,23 +130,181 @@ func ParseUpstream(s string) (Service, error) {
 			Partition: "default",
 		}
 	}
-	if len(qname) > 2 {
+	if len(qname) > 3 && qname[3] == peerSuffix {
+		upstream.Peer = qname[2]
+	} else if len(qname) > 2 {
 		upstream.Partition = qname[2]
 	}
 
 	// Optional datacenter
 	if len(parts) > 2 {
+		if upstream.Peer != "" {
+			return upstream, fmt.Errorf("invalid service format: datacenter cannot be set for a peered service: %s", s)
+		}
 		upstream.Datacenter = parts[2]
 	}
 
 	return upstream, nil
 }
 
+// parseLabeledUpstream parses a string in labeled upstream format into a Service instance.
+// The supported fields are:
+//   - service: the name of the upstream service (required).
+//   - namespace, partition: the namespace and admin partition of the service [Consul Enterprise].
+//   - datacenter: the datacenter of the service. It cannot be set for a peered service.
+//   - peer: the name of the cluster peer that the service is imported from.
+//   - subset: the service subset, as defined by a service-resolver. It cannot be set for a peered service.
+//   - port: the local port that the upstream listens on.
+//   - bind: the local IP address that the upstream listens on.
+//   - socket: the path of the Unix domain socket that the upstream listens on instead of a port.
+//   - protocol: the protocol of the upstream, either tcp or http.
+//   - timeout: the request timeout for an http upstream, for example 5s.
+//   - retries: the number of retries of idempotent requests for an http upstream.
+func parseLabeledUpstream(s string) (Service, error) {
+	var upstream Service
+	var ns, ap string
+
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !ok {
+			return upstream, fmt.Errorf("invalid upstream field %q: expected field=value: %s", pair, s)
+		}
+		if seen[key] {
+			return upstream, fmt.Errorf("invalid upstream field %q: field is set more than once: %s", key, s)
+		}
+		seen[key] = true
+		if value == "" {
+			return upstream, fmt.Errorf("invalid upstream field %q: value must not be empty: %s", key, s)
+		}
+
+		switch key {
+		case labelService:
+			upstream.Name = value
+		case labelNamespace:
+			ns = value
+		case labelPartition:
+			ap = value
+		case labelDatacenter:
+			upstream.Datacenter = value
+		case labelPeer:
+			upstream.Peer = value
+		case labelSubset:
+			if !validSubset.MatchString(value) {
+				return upstream, fmt.Errorf("invalid upstream field %q: %q is not a valid subset name: %s", key, value, s)
+			}
+			upstream.Subset = value
+		case labelPort:
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return upstream, fmt.Errorf("invalid upstream field %q: %q is not a valid port: %s", key, value, s)
+			}
+			upstream.Port = port
+		case labelBind:
+			if net.ParseIP(value) == nil {
+				return upstream, fmt.Errorf("invalid upstream field %q: %q is not an IP address: %s", key, value, s)
+			}
+			upstream.BindAddress = value
+		case labelSocket:
+			upstream.SocketPath = value
+		case labelProtocol:
+			if value != ProtocolTCP && value != ProtocolHTTP {
+				return upstream, fmt.Errorf("invalid upstream field %q: protocol must be %s or %s: %s", key, ProtocolTCP, ProtocolHTTP, s)
+			}
+			upstream.Protocol = value
+		case labelTimeout:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return upstream, fmt.Errorf("invalid upstream field %q: %q is not a valid timeout: %s", key, value, s)
+			}
+			upstream.RequestTimeout = timeout
+		case labelRetries:
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				return upstream, fmt.Errorf("invalid upstream field %q: %q is not a valid number of retries: %s", key, value, s)
+			}
+			upstream.Retries = retries
+		default:
+			return upstream, fmt.Errorf("invalid upstream field %q: unknown field: %s", key, s)
+		}
+	}
+
+	if !seen[labelService] {
+		return upstream, fmt.Errorf("invalid upstream field %q: field is required: %s", labelService, s)
+	}
+	if upstream.SocketPath != "" {
+		for _, key := range []string{labelPort, labelBind} {
+			if seen[key] {
+				return upstream, fmt.Errorf("invalid upstream field %q: %s cannot be set for a socket upstream: %s", key, key, s)
+			}
+		}
+	} else if !seen[labelPort] {
+		return upstream, fmt.Errorf("invalid upstream field %q: field is required: %s", labelPort, s)
+	}
+	if upstream.Protocol != ProtocolHTTP {
+		for _, key := range []string{labelTimeout, labelRetries} {
+			if seen[key] {
+				return upstream, fmt.Errorf("invalid upstream field %q: %s can only be set for an %s upstream: %s", key, key, ProtocolHTTP, s)
+			}
+		}
+	}
+	if upstream.Peer != "" {
+		if upstream.Datacenter != "" {
+			return upstream, fmt.Errorf("invalid upstream field %q: datacenter cannot be set for a peered service: %s", labelDatacenter, s)
+		}
+		if upstream.Subset != "" {
+			return upstream, fmt.Errorf("invalid upstream field %q: subset cannot be set for a peered service: %s", labelSubset, s)
+		}
+	}
+	upstream.EnterpriseMeta = NewEnterpriseMeta(ap, ns)
+
+	return upstream, nil
+}
+
+// String returns the service in labeled upstream format.
+// The result can be parsed by ParseUpstream to return the same service, excluding its trust domain.
+func (s Service) String() string {
+	fields := []string{labelService + "=" + s.Name}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	if s.EnterpriseMeta != nil {
+		add(labelNamespace, s.Namespace)
+		add(labelPartition, s.Partition)
+	}
+	add(labelDatacenter, s.Datacenter)
+	add(labelPeer, s.Peer)
+	add(labelSubset, s.Subset)
+	if s.Port != 0 {
+		add(labelPort, strconv.Itoa(s.Port))
+	}
+	add(labelBind, s.BindAddress)
+	add(labelSocket, s.SocketPath)
+	add(labelProtocol, s.Protocol)
+	if s.RequestTimeout != 0 {
+		add(labelTimeout, s.RequestTimeout.String())
+	}
+	if s.Retries != 0 {
+		add(labelRetries, strconv.Itoa(s.Retries))
+	}
+	return strings.Join(fields, ",")
+}
+
+// SNI returns the server name that routes a connection to the service through a mesh gateway.
+// For a peered service the TrustDomain must be the trust domain of the peer.
 func (s Service) SNI() string {
 	ns := s.NamespaceOrDefault()
 	ap := s.PartitionOrDefault()
 	dc := s.DatacenterOrDefault()
 
+	if s.Peer != "" {
+		return dotJoin(s.Name, ns, ap, s.Peer, external, s.TrustDomain)
+	}
+
 	switch ap {
 	case "default":
 		if s.Subset == "" {
@@ -149,6 +369,24 @@ func (s Service) ExtensionPath() string {
 	return fmt.Sprintf("/%s/%s/%s", s.PartitionOrDefault(), s.NamespaceOrDefault(), s.Name)
 }
 
+// Hostnames returns the host names that identify the service to the function.
+// The names are `[subset.]name.virtual.consul` and the fully qualified `[subset.]name.namespace.partition.datacenter`,
+// or `name.namespace.peer-name.peer` for a service imported from a cluster peer.
+func (s Service) Hostnames() []string {
+	name := s.Name
+	if s.Subset != "" {
+		name = dotJoin(s.Subset, s.Name)
+	}
+	qualified := dotJoin(name, s.NamespaceOrDefault(), s.PartitionOrDefault(), s.DatacenterOrDefault())
+	if s.Peer != "" {
+		qualified = dotJoin(name, s.NamespaceOrDefault(), s.Peer, peerSuffix)
+	}
+	return []string{
+		strings.ToLower(dotJoin(name, "virtual", "consul")),
+		strings.ToLower(qualified),
+	}
+}
+
 func dotJoin(parts ...string) string {
 	return strings.Join(parts, ".")
 }
@@ -5,6 +5,7 @@ package structs_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/require"
@@ -23,6 +24,8 @@ const (
 	internal        = "internal"
 	version         = "v1"
 	internalVersion = internal + "-" + version
+	external        = "external"
+	peer            = "peer1"
 )
 
 func TestService(t *testing.T) {
@@ -63,6 +66,18 @@ func TestService(t *testing.T) {
 			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ap/ap1/ns/ns1/dc/dc2/svc/test-service",
 			path: "/ap1/ns1/test-service",
 		},
+		"service, ns, peer": {
+			up:   structs.Service{TrustDomain: td, Name: svc, Port: port, Peer: peer, EnterpriseMeta: &structs.EnterpriseMeta{Namespace: ns, Partition: "default"}},
+			str:  "test-service.ns1.peer1.peer:1234",
+			sni:  "test-service.ns1.default.peer1." + external + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ns/ns1/dc/dc1/svc/test-service",
+			path: "/default/ns1/test-service",
+		},
+		"peer with datacenter": {
+			up:  structs.Service{},
+			str: "test-service.ns1.peer1.peer:1234:dc2",
+			err: "datacenter cannot be set for a peered service",
+		},
 		"invalid service format": {
 			up:  structs.Service{},
 			str: svc,
@@ -73,6 +88,131 @@ func TestService(t *testing.T) {
 			str: "invalid:port",
 			err: "invalid service port",
 		},
+		"labeled service only": {
+			up:   structs.Service{TrustDomain: td, Name: svc, Port: port},
+			str:  "service=test-service,port=1234",
+			sni:  "test-service.default.dc1." + internal + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ns/default/dc/dc1/svc/test-service",
+			path: "/default/default/test-service",
+		},
+		"labeled all fields": {
+			up: structs.Service{
+				TrustDomain:    td,
+				Name:           svc,
+				Port:           port,
+				Datacenter:     dc,
+				Subset:         "v2",
+				BindAddress:    "127.0.0.2",
+				EnterpriseMeta: &structs.EnterpriseMeta{Namespace: ns, Partition: ap},
+			},
+			str:  "service=test-service, namespace=ns1, partition=ap1, datacenter=dc2, subset=v2, port=1234, bind=127.0.0.2",
+			sni:  "v2.test-service.ns1.ap1.dc2." + internalVersion + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ap/ap1/ns/ns1/dc/dc2/svc/test-service",
+			path: "/ap1/ns1/test-service",
+		},
+		"labeled subset": {
+			up:   structs.Service{TrustDomain: td, Name: svc, Port: port, Subset: "v2", EnterpriseMeta: &structs.EnterpriseMeta{Namespace: ns, Partition: "default"}},
+			str:  "service=test-service,namespace=ns1,subset=v2,port=1234",
+			sni:  "v2.test-service.ns1.dc1." + internal + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ns/ns1/dc/dc1/svc/test-service",
+			path: "/default/ns1/test-service",
+		},
+		"labeled subset, ap": {
+			up:   structs.Service{TrustDomain: td, Name: svc, Port: port, Subset: "v2", EnterpriseMeta: &structs.EnterpriseMeta{Namespace: "default", Partition: ap}},
+			str:  "service=test-service,partition=ap1,subset=v2,port=1234",
+			sni:  "v2.test-service.default.ap1.dc1." + internalVersion + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ap/ap1/ns/default/dc/dc1/svc/test-service",
+			path: "/ap1/default/test-service",
+		},
+		"labeled peer": {
+			up:   structs.Service{TrustDomain: td, Name: svc, Port: port, Peer: peer, EnterpriseMeta: &structs.EnterpriseMeta{Namespace: ns, Partition: "default"}},
+			str:  "port=1234,peer=peer1,namespace=ns1,service=test-service",
+			sni:  "test-service.ns1.default.peer1." + external + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ns/ns1/dc/dc1/svc/test-service",
+			path: "/default/ns1/test-service",
+		},
+		"labeled socket": {
+			up:   structs.Service{TrustDomain: td, Name: svc, SocketPath: "api.sock"},
+			str:  "service=test-service,socket=api.sock",
+			sni:  "test-service.default.dc1." + internal + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ns/default/dc/dc1/svc/test-service",
+			path: "/default/default/test-service",
+		},
+		"labeled socket with port": {
+			str: "service=test-service,socket=/tmp/api.sock,port=1234",
+			err: `invalid upstream field "port": port cannot be set for a socket upstream`,
+		},
+		"labeled socket with bind": {
+			str: "service=test-service,socket=/tmp/api.sock,bind=127.0.0.2",
+			err: `invalid upstream field "bind": bind cannot be set for a socket upstream`,
+		},
+		"labeled http": {
+			up:   structs.Service{TrustDomain: td, Name: svc, Port: port, Protocol: structs.ProtocolHTTP, RequestTimeout: 1500 * time.Millisecond, Retries: 2},
+			str:  "service=test-service,port=1234,protocol=http,timeout=1.5s,retries=2",
+			sni:  "test-service.default.dc1." + internal + "." + td,
+			sid:  "spiffe://ba471007-78d1-3261-2e02-24258f2cb341.consul/ns/default/dc/dc1/svc/test-service",
+			path: "/default/default/test-service",
+		},
+		"labeled invalid protocol": {
+			str: "service=test-service,port=1234,protocol=grpc",
+			err: `invalid upstream field "protocol": protocol must be tcp or http`,
+		},
+		"labeled invalid timeout": {
+			str: "service=test-service,port=1234,protocol=http,timeout=5",
+			err: `invalid upstream field "timeout": "5" is not a valid timeout`,
+		},
+		"labeled invalid retries": {
+			str: "service=test-service,port=1234,protocol=http,retries=-1",
+			err: `invalid upstream field "retries": "-1" is not a valid number of retries`,
+		},
+		"labeled retries without http": {
+			str: "service=test-service,port=1234,retries=1",
+			err: `invalid upstream field "retries": retries can only be set for an http upstream`,
+		},
+		"labeled missing service": {
+			str: "port=1234",
+			err: `invalid upstream field "service": field is required`,
+		},
+		"labeled missing port": {
+			str: "service=test-service",
+			err: `invalid upstream field "port": field is required`,
+		},
+		"labeled unknown field": {
+			str: "service=test-service,port=1234,dc=dc2",
+			err: `invalid upstream field "dc": unknown field`,
+		},
+		"labeled duplicate field": {
+			str: "service=test-service,port=1234,port=1235",
+			err: `invalid upstream field "port": field is set more than once`,
+		},
+		"labeled missing value": {
+			str: "service=test-service,port=1234,subset",
+			err: `invalid upstream field "subset": expected field=value`,
+		},
+		"labeled empty value": {
+			str: "service=test-service,port=1234,namespace=",
+			err: `invalid upstream field "namespace": value must not be empty`,
+		},
+		"labeled invalid port": {
+			str: "service=test-service,port=65536",
+			err: `invalid upstream field "port": "65536" is not a valid port`,
+		},
+		"labeled invalid bind": {
+			str: "service=test-service,port=1234,bind=localhost",
+			err: `invalid upstream field "bind": "localhost" is not an IP address`,
+		},
+		"labeled peer with datacenter": {
+			str: "service=test-service,port=1234,peer=peer1,datacenter=dc2",
+			err: `invalid upstream field "datacenter": datacenter cannot be set for a peered service`,
+		},
+		"labeled invalid subset": {
+			str: "service=test-service,port=1234,subset=V2.x",
+			err: `invalid upstream field "subset": "V2.x" is not a valid subset name`,
+		},
+		"labeled peer with subset": {
+			str: "service=test-service,port=1234,peer=peer1,subset=v2",
+			err: `invalid upstream field "subset": subset cannot be set for a peered service`,
+		},
 	}
 
 	for n, c := range cases {
@@ -87,6 +227,12 @@ func TestService(t *testing.T) {
 				require.Equal(t, c.sni, obs.SNI())
 				require.Equal(t, c.sid, obs.SpiffeID())
 				require.Equal(t, c.path, obs.ExtensionPath())
+
+				// The string form round-trips.
+				rt, err := structs.ParseUpstream(obs.String())
+				require.NoError(t, err)
+				rt.TrustDomain = td
+				require.True(t, cmp.Equal(obs, rt), cmp.Diff(obs, rt))
 			} else {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), c.err)
@@ -94,3 +240,35 @@ func TestService(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceHostnames(t *testing.T) {
+	cases := map[string]struct {
+		str   string
+		hosts []string
+	}{
+		"service only": {
+			str:   "test-service:1234",
+			hosts: []string{"test-service.virtual.consul", "test-service.default.default.dc1"},
+		},
+		"service, ns, ap, dc": {
+			str:   "test-service.ns1.ap1:1234:dc2",
+			hosts: []string{"test-service.virtual.consul", "test-service.ns1.ap1.dc2"},
+		},
+		"subset": {
+			str:   "service=Test-Service,subset=v2,port=1234",
+			hosts: []string{"v2.test-service.virtual.consul", "v2.test-service.default.default.dc1"},
+		},
+		"peer": {
+			str:   "test-service.ns1.peer1.peer:1234",
+			hosts: []string{"test-service.virtual.consul", "test-service.ns1.peer1.peer"},
+		},
+	}
+
+	for n, c := range cases {
+		t.Run(n, func(t *testing.T) {
+			up, err := structs.ParseUpstream(c.str)
+			require.NoError(t, err)
+			require.Equal(t, c.hosts, up.Hostnames())
+		})
+	}
+}
@@ -53,7 +53,8 @@ resource "aws_lambda_function" "lambda_app" {
         CONSUL_SERVICE_PARTITION = var.consul_partition
       } : {},
       length(var.consul_upstreams) > 0 ? {
-        CONSUL_SERVICE_UPSTREAMS = join(",", var.consul_upstreams)
+        # Labeled upstreams contain commas so they are separated by semicolons.
+        CONSUL_SERVICE_UPSTREAMS = join(length(regexall("=", join("", var.consul_upstreams))) > 0 ? ";" : ",", var.consul_upstreams)
       } : {}
     )
   }
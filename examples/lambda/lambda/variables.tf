@@ -53,7 +53,7 @@ variable "consul_namespace" {
 }
 
 variable "consul_upstreams" {
-  description = "List of Consul service mesh upstreams the Lambda function will call."
+  description = "List of Consul service mesh upstreams the Lambda function will call. Each upstream is either in the format `name[.namespace[.partition]]:port[:datacenter]`, in the format `name.namespace.peer-name.peer:port` for a service imported from a cluster peer, or in the labeled format, for example `service=api,namespace=ns1,subset=v2,port=1234,bind=127.0.0.2`. Upstreams listen on 127.0.0.1 unless the labeled format sets a different loopback `bind` address, so upstreams with different bind addresses can share a port. The `.peer` suffix is required because `name.namespace.peer-name:port` is the format of a service in a partition; the labeled format sets the peer with `peer=peer-name` instead. Set `socket=api.sock` instead of a port to listen on a Unix domain socket in the `/tmp` directory. Set `protocol=http` to parse HTTP requests so that a request `timeout` and `retries` of idempotent requests can be set."
   type        = list(string)
   default     = []
 }
@@ -71,13 +71,13 @@ variable "consul_extension_data_prefix" {
 }
 
 variable "consul_refresh_frequency" {
-  description = "The amount of time to wait between refreshes of Consul state. Provided in Go time.Duration format (e.g.: 5m)."
+  description = "The maximum age of the cached Consul state. The state is refreshed on the next invocation after it becomes older than this. Provided in Go time.Duration format (e.g.: 5m)."
   type        = string
   default     = ""
 }
 
 variable "consul_mesh_gateway_uri" {
-  description = "The URI of the mesh gateway. This is required for Lambda functions that call into the Consul service mesh."
+  description = "The URI of the mesh gateway, or a comma-separated list of mesh gateway URIs to fail over between. Mesh gateways discovered by the Lambda registrator take precedence over this value, which is only required for Lambda functions that call into the Consul service mesh when discovery is disabled."
   type        = string
   default     = ""
 }
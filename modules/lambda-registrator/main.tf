@@ -24,6 +24,14 @@ locals {
   ecr_repo_name     = var.private_ecr_repo_name == "" ? "consul-lambda-registrator-${random_id.repo_id.hex}" : var.private_ecr_repo_name
   # generated_ecr_image_uri is used when we want to automatically push the public image to a private ecr repo using docker.
   generated_ecr_image_uri = "${data.aws_caller_identity.current_identity.account_id}.dkr.ecr.${data.aws_region.current_region.name}.amazonaws.com/${local.ecr_repo_name}:${local.image_tag}"
+  # The scheme of the extension data prefix selects the store: sm:// for Secrets Manager, otherwise Parameter Store.
+  extension_data_in_secrets_manager = startswith(var.consul_extension_data_prefix, "sm://")
+  extension_data_path               = "/${trimprefix(trimprefix(trimprefix(var.consul_extension_data_prefix, "sm://"), "ssm://"), "/")}"
+  # IAM does not authorize KMS operations through an alias ARN, so access to a key given by its alias ARN
+  # is granted on the keys in the alias's account and region that the alias refers to.
+  kms_key_arn_parts = split(":", var.consul_extension_data_kms_key_id)
+  kms_key_is_arn    = length(local.kms_key_arn_parts) == 6 && startswith(var.consul_extension_data_kms_key_id, "arn:")
+  kms_key_is_alias  = local.kms_key_is_arn && startswith(element(local.kms_key_arn_parts, 5), "alias/")
 }
 
 # Equivalent of aws ecr get-login
@@ -101,6 +109,26 @@ resource "aws_iam_policy" "policy" {
       "Resource": "arn:aws:ssm:*:*:parameter${var.consul_http_token_path}"
     },
 %{endif~}
+%{if var.consul_extension_data_prefix != "" && local.kms_key_is_arn~}
+    {
+      "Effect": "Allow",
+      "Action": [
+        "kms:Encrypt",
+        "kms:Decrypt",
+        "kms:GenerateDataKey"
+      ],
+%{if local.kms_key_is_alias~}
+      "Resource": "${join(":", slice(local.kms_key_arn_parts, 0, 5))}:key/*",
+      "Condition": {
+        "ForAnyValue:StringEquals": {
+          "kms:ResourceAliases": "${element(local.kms_key_arn_parts, 5)}"
+        }
+      }
+%{else~}
+      "Resource": "${var.consul_extension_data_kms_key_id}"
+%{endif~}
+    },
+%{endif~}
 %{if local.on_vpc~}
     {
       "Action": [
@@ -113,14 +141,31 @@ resource "aws_iam_policy" "policy" {
       "Effect": "Allow"
     },
 %{endif~}
-%{if var.consul_extension_data_prefix != ""~}
+%{if var.consul_extension_data_prefix != "" && !local.extension_data_in_secrets_manager~}
     {
       "Effect": "Allow",
       "Action": [
+        "ssm:GetParameter",
         "ssm:PutParameter",
-        "ssm:DeleteParameter"
+        "ssm:DeleteParameter",
+        "ssm:AddTagsToResource"
       ],
-      "Resource": "arn:aws:ssm:*:*:parameter${var.consul_extension_data_prefix}/*"
+      "Resource": "arn:aws:ssm:*:*:parameter${local.extension_data_path}/*"
+    },
+%{endif~}
+%{if local.extension_data_in_secrets_manager~}
+    {
+      "Effect": "Allow",
+      "Action": [
+        "secretsmanager:CreateSecret",
+        "secretsmanager:GetSecretValue",
+        "secretsmanager:PutSecretValue",
+        "secretsmanager:DeleteSecret",
+        "secretsmanager:DescribeSecret",
+        "secretsmanager:RestoreSecret",
+        "secretsmanager:TagResource"
+      ],
+      "Resource": "arn:aws:secretsmanager:*:*:secret:${local.extension_data_path}/*"
     },
 %{endif~}
     {
@@ -141,7 +186,8 @@ resource "aws_iam_policy" "policy" {
     },
     {
       "Action": [
-        "lambda:ListFunctions"
+        "lambda:ListFunctions",
+        "tag:GetResources"
       ],
       "Resource": "*",
       "Effect": "Allow"
@@ -195,9 +241,11 @@ resource "aws_lambda_function" "registration" {
   environment {
     variables = merge(
       {
-        CONSUL_HTTP_ADDR = var.consul_http_addr,
-        NODE_NAME        = var.node_name,
-        ENTERPRISE       = var.enterprise,
+        CONSUL_HTTP_ADDR          = var.consul_http_addr,
+        NODE_NAME                 = var.node_name,
+        ENTERPRISE                = var.enterprise,
+        MESH_GATEWAY_SERVICE_NAME = var.mesh_gateway_service_name,
+        LAMBDA_LIST_STRATEGY      = var.lambda_list_strategy,
       },
       length(var.partitions) > 0 ? {
         PARTITIONS = join(",", var.partitions),
@@ -209,11 +257,32 @@ resource "aws_lambda_function" "registration" {
         CONSUL_CACERT_PATH = var.consul_ca_cert_path
         CONSUL_HTTP_SSL    = "true"
       } : {},
+      var.consul_grpc_addr != "" ? {
+        CONSUL_GRPC_ADDR = var.consul_grpc_addr
+      } : {},
       var.consul_extension_data_prefix != "" ? {
         CONSUL_EXTENSION_DATA_PREFIX = var.consul_extension_data_prefix
       } : {},
+      var.consul_extension_data_recovery_window != 0 ? {
+        CONSUL_EXTENSION_DATA_RECOVERY_WINDOW = var.consul_extension_data_recovery_window
+      } : {},
+      var.consul_extension_data_kms_key_id != "" ? {
+        CONSUL_EXTENSION_DATA_KMS_KEY_ID = var.consul_extension_data_kms_key_id
+      } : {},
+      length(var.consul_extension_data_tags) > 0 ? {
+        CONSUL_EXTENSION_DATA_TAGS = jsonencode(var.consul_extension_data_tags)
+      } : {},
+      var.consul_extension_data_expiration ? {
+        CONSUL_EXTENSION_DATA_EXPIRATION = "true"
+      } : {},
       var.consul_extension_data_tier != "" ? {
         CONSUL_EXTENSION_DATA_TIER = var.consul_extension_data_tier
+      } : {},
+      var.dry_run ? {
+        DRY_RUN = "true"
+      } : {},
+      var.fail_on_reconcile_error ? {
+        FAIL_ON_RECONCILE_ERROR = "true"
       } : {}
     )
   }
@@ -254,6 +323,17 @@ module "eventbridge" {
             "UntagResource20170331v2",
             "UntagResource20170331",
             "UntagResource",
+            "UpdateFunctionConfiguration20150331v2",
+            "UpdateFunctionConfiguration20150331",
+            "UpdateFunctionConfiguration",
+            "DeleteFunction20150331",
+            "DeleteFunction",
+            "CreateAlias20150331",
+            "CreateAlias",
+            "UpdateAlias20150331",
+            "UpdateAlias",
+            "DeleteAlias20150331",
+            "DeleteAlias",
           ]
         }
       })
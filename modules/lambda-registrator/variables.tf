@@ -11,6 +11,12 @@ variable "consul_http_addr" {
   type        = string
 }
 
+variable "consul_grpc_addr" {
+  description = "The gRPC address of the Consul server. This must be a full URL, including port and scheme, e.g. https://consul.example.com:8503. When set, Lambda registrator has leaf certificates signed by the Consul Connect CA with the correct identity for each service's admin partition."
+  type        = string
+  default     = ""
+}
+
 variable "consul_datacenter" {
   description = "The Consul datacenter that the Lambda registrator is part of."
   type        = string
@@ -30,11 +36,40 @@ variable "consul_http_token_path" {
 }
 
 variable "consul_extension_data_prefix" {
-  description = "The path within Parameter Store where Lambda registrator will write the Consul Lambda extension data. If this is unset, Lambda registrator will not write Consul data to Parameter Store."
+  description = "The path where Lambda registrator will write the Consul Lambda extension data. Prefix the path with sm:// to store the data in Secrets Manager instead of Parameter Store. Functions that read their extension data from Secrets Manager need the secretsmanager:GetSecretValue and secretsmanager:DescribeSecret permissions. If this is unset, Lambda registrator will not write Consul data."
+  type        = string
+  default     = ""
+}
+
+variable "consul_extension_data_recovery_window" {
+  description = "The number of days that deleted extension data can be recovered when it is stored in Secrets Manager. This must be 0 or between 7 and 30. If this is 0 the data is deleted immediately."
+  type        = number
+  default     = 0
+
+  validation {
+    condition     = var.consul_extension_data_recovery_window == 0 || (var.consul_extension_data_recovery_window >= 7 && var.consul_extension_data_recovery_window <= 30)
+    error_message = "The recovery window must be 0 or between 7 and 30 days."
+  }
+}
+
+variable "consul_extension_data_kms_key_id" {
+  description = "The ID, ARN or alias of the KMS key used to encrypt the Consul Lambda extension data. If this is unset, the account's default key for the store is used. Lambda registrator is only granted access to the key when its key ARN or alias ARN is given; otherwise access to the key must be granted by the key policy."
   type        = string
   default     = ""
 }
 
+variable "consul_extension_data_tags" {
+  description = "AWS tags to apply to the Consul Lambda extension data of every function. The ARN, Consul partition and namespace of each function are also applied as tags."
+  type        = map(string)
+  default     = {}
+}
+
+variable "consul_extension_data_expiration" {
+  description = "Whether to attach an expiration policy to the Consul Lambda extension data in Parameter Store that deletes the data when its mTLS leaf certificate expires. Parameter policies require the advanced tier, so enabling this writes the extension data to the advanced tier. If Lambda registrator fails to rotate a certificate before it expires, the extension treats the deleted data as the function being removed from the service mesh and closes all of its outbound connections until the data is written again."
+  type        = bool
+  default     = false
+}
+
 variable "consul_extension_data_tier" {
   description = <<-EOT
   The tier to use for storing data in Parameter Store.
@@ -51,6 +86,12 @@ variable "node_name" {
   default     = "lambdas"
 }
 
+variable "mesh_gateway_service_name" {
+  description = "The name of the mesh gateway service in Consul. When set, the addresses of its healthy instances are written to the extension data so that Lambda functions discover the mesh gateways instead of using the configured addresses. Discovery is disabled by default."
+  type        = string
+  default     = ""
+}
+
 variable "enterprise" {
   description = "Determines if Consul Enterprise is being used [Consul Enterprise]."
   type        = bool
@@ -63,6 +104,29 @@ variable "partitions" {
   default     = []
 }
 
+variable "dry_run" {
+  description = "When true, Lambda registrator does not change Consul or Parameter Store and instead returns a plan of the changes it would make."
+  type        = bool
+  default     = false
+}
+
+variable "lambda_list_strategy" {
+  description = "Determines how Lambda functions are listed during a full sync. Set to \"lambda\" to list them with the Lambda API or \"tagging\" to list them and their tags in bulk with the Resource Groups Tagging API."
+  type        = string
+  default     = "lambda"
+
+  validation {
+    condition     = contains(["lambda", "tagging"], var.lambda_list_strategy)
+    error_message = "The lambda_list_strategy must be either \"lambda\" or \"tagging\"."
+  }
+}
+
+variable "fail_on_reconcile_error" {
+  description = "When true, Lambda registrator invocations fail if any event fails to reconcile. By default the invocation succeeds and returns a report that counts the failed events."
+  type        = bool
+  default     = false
+}
+
 variable "timeout" {
   description = "The maximum number of seconds Lambda registrator can run before timing out."
   type        = number